@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	papilite "github.com/murkyl/go-papi-lite"
+)
+
+// Default table columns of the typed commands
+var (
+	userColumns   = []string{"name", "enabled", "email", "home_directory", "primary_group.name", "shell"}
+	zoneColumns   = []string{"name", "zone_id", "path", "groupnet", "auth_providers"}
+	s3KeyColumns  = []string{"access_id", "secret_key", "secret_key_timestamp", "old_key_expiry"}
+	profileColumn = []string{"name", "endpoint", "user", "default"}
+)
+
+// cli holds the state of a single command invocation
+type cli struct {
+	opts   globalOptions
+	stdout io.Writer
+	stderr io.Writer
+	cfg    *config
+	out    *printer
+}
+
+// dispatch runs the command in args
+func (c *cli) dispatch(ctx context.Context, args []string) error {
+	cfg, err := loadConfig(c.opts.configPath)
+	if err != nil {
+		return err
+	}
+	c.cfg = cfg
+	format := c.opts.output
+	if p, ok := cfg.Profiles[c.profileName()]; ok && p.Output != "" && !c.opts.outputSet {
+		format = p.Output
+	}
+	if c.out, err = newPrinter(format, c.stdout); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "get":
+		return c.get(ctx, args[1:])
+	case "raw":
+		return c.raw(ctx, args[1:])
+	case "users":
+		return c.users(ctx, args[1:])
+	case "zones":
+		return c.zones(ctx, args[1:])
+	case "s3":
+		return c.s3(ctx, args[1:])
+	case "profiles":
+		return c.profiles(args[1:])
+	}
+	return usageError(fmt.Sprintf("unknown command %s", args[0]))
+}
+
+// profileName returns the name of the selected profile
+func (c *cli) profileName() string {
+	if c.opts.profile != "" {
+		return c.opts.profile
+	}
+	return c.cfg.DefaultProfile
+}
+
+// connect opens a connection to the cluster of the selected profile
+func (c *cli) connect(ctx context.Context) (*papilite.OnefsConn, error) {
+	p, err := c.cfg.resolveProfile(c.opts)
+	if err != nil {
+		return nil, err
+	}
+	onefsCfg := p.onefsCfg()
+	if c.opts.debug {
+		onefsCfg.Logger = stderrLogger{w: c.stderr}
+	} else {
+		onefsCfg.Logger = papilite.NopLogger{}
+	}
+	conn := papilite.NewPapiConn()
+	if err := conn.ConnectContext(ctx, onefsCfg); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// withConn connects to the cluster, runs fn and disconnects again
+func (c *cli) withConn(ctx context.Context, fn func(conn *papilite.OnefsConn) error) error {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.DisconnectContext(context.Background())
+	return fn(conn)
+}
+
+// get implements: get <path> [--query key=value]...
+func (c *cli) get(ctx context.Context, args []string) error {
+	query := queryFlag{}
+	fs := newFlagSet("get")
+	fs.Var(query, "query", "Query argument in the form key=value, can be repeated")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("get requires exactly one path")
+	}
+	return c.send(ctx, "GET", positional[0], query, nil)
+}
+
+// raw implements: raw <method> <path> [--body file] [--query key=value]...
+func (c *cli) raw(ctx context.Context, args []string) error {
+	query := queryFlag{}
+	var bodyFile string
+	fs := newFlagSet("raw")
+	fs.Var(query, "query", "Query argument in the form key=value, can be repeated")
+	fs.StringVar(&bodyFile, "body", "", "File with the JSON request body, - reads from stdin")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usageError("raw requires a method and a path")
+	}
+	var body interface{}
+	if bodyFile != "" {
+		data, err := readBody(bodyFile)
+		if err != nil {
+			return err
+		}
+		body = data
+	}
+	return c.send(ctx, strings.ToUpper(positional[0]), positional[1], query, body)
+}
+
+// send performs a request with automatic pagination and prints the combined result
+func (c *cli) send(ctx context.Context, method string, path string, query queryFlag, body interface{}) error {
+	return c.withConn(ctx, func(conn *papilite.OnefsConn) error {
+		result, err := conn.Papi.SendContext(ctx, method, strings.TrimPrefix(path, "/"), query, body, nil)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			return nil
+		}
+		return c.out.print(result)
+	})
+}
+
+// readBody reads a request body from a file or stdin
+func readBody(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// users implements: users list [--zone name] and users get <name> [--zone name]
+func (c *cli) users(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return usageError("users requires a sub command: list or get")
+	}
+	var zone string
+	fs := newFlagSet("users")
+	fs.StringVar(&zone, "zone", "", "Access zone name")
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "list":
+		if len(positional) != 0 {
+			return usageError("users list does not take arguments")
+		}
+		return c.withConn(ctx, func(conn *papilite.OnefsConn) error {
+			users, err := conn.GetUserListContext(ctx, zone)
+			if err != nil {
+				return err
+			}
+			return c.out.print(users, userColumns...)
+		})
+	case "get":
+		if len(positional) != 1 {
+			return usageError("users get requires exactly one user name")
+		}
+		return c.withConn(ctx, func(conn *papilite.OnefsConn) error {
+			user, err := conn.GetUserContext(ctx, positional[0], zone)
+			if err != nil {
+				return err
+			}
+			return c.out.print(user)
+		})
+	}
+	return usageError(fmt.Sprintf("unknown users sub command %s", args[0]))
+}
+
+// zones implements: zones list
+func (c *cli) zones(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] != "list" {
+		return usageError("zones requires the sub command list")
+	}
+	return c.withConn(ctx, func(conn *papilite.OnefsConn) error {
+		zones, err := conn.GetAccessZoneListContext(ctx)
+		if err != nil {
+			return err
+		}
+		return c.out.print(zones, zoneColumns...)
+	})
+}
+
+// s3 implements: s3 key rotate <user> [--zone name] [--ttl minutes]
+func (c *cli) s3(ctx context.Context, args []string) error {
+	if len(args) < 2 || args[0] != "key" || args[1] != "rotate" {
+		return usageError("s3 requires the sub command key rotate")
+	}
+	var zone string
+	var ttl int
+	fs := newFlagSet("s3")
+	fs.StringVar(&zone, "zone", "", "Access zone name")
+	fs.IntVar(&ttl, "ttl", 0, "Minutes the old key stays valid")
+	positional, err := parseArgs(fs, args[2:])
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("s3 key rotate requires exactly one user name")
+	}
+	return c.withConn(ctx, func(conn *papilite.OnefsConn) error {
+		key, err := conn.GetS3TokenContext(ctx, positional[0], zone, ttl)
+		if err != nil {
+			return err
+		}
+		return c.out.print(key, s3KeyColumns...)
+	})
+}
+
+// profiles implements: profiles list
+func (c *cli) profiles(args []string) error {
+	if len(args) != 1 || args[0] != "list" {
+		return usageError("profiles requires the sub command list")
+	}
+	var rows []map[string]interface{}
+	for _, name := range c.cfg.profileNames() {
+		p := c.cfg.Profiles[name]
+		rows = append(rows, map[string]interface{}{
+			"name":     name,
+			"endpoint": p.Endpoint,
+			"user":     p.User,
+			"default":  name == c.cfg.DefaultProfile,
+		})
+	}
+	if rows == nil {
+		rows = []map[string]interface{}{}
+	}
+	return c.out.print(rows, profileColumn...)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	papilite "github.com/murkyl/go-papi-lite"
+)
+
+const (
+	configEnvVar          string = "PAPI_CONFIG"
+	passwordEnvVar        string = "PAPI_PASSWORD"
+	defaultConfigFileName string = ".papi.json"
+)
+
+// config is the content of the configuration file
+type config struct {
+	DefaultProfile string              `json:"default_profile,omitempty"`
+	Profiles       map[string]*profile `json:"profiles"`
+}
+
+// profile contains the connection settings for one cluster
+type profile struct {
+	Endpoint     string `json:"endpoint"`
+	User         string `json:"user,omitempty"`
+	Password     string `json:"password,omitempty"`
+	PasswordEnv  string `json:"password_env,omitempty"`
+	PasswordFile string `json:"password_file,omitempty"`
+	Insecure     bool   `json:"insecure,omitempty"`
+	BasicAuth    bool   `json:"basic_auth,omitempty"`
+	CACertFile   string `json:"ca_cert_file,omitempty"`
+	ServerName   string `json:"server_name,omitempty"`
+	Output       string `json:"output,omitempty"`
+}
+
+// configPath returns the configuration file to use and whether it was explicitly requested
+func configPath(flagValue string) (string, bool) {
+	if flagValue != "" {
+		return flagValue, true
+	}
+	if path := os.Getenv(configEnvVar); path != "" {
+		return path, true
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(home, defaultConfigFileName), false
+}
+
+// loadConfig reads the configuration file. A missing default configuration file results in an empty configuration
+func loadConfig(flagValue string) (*config, error) {
+	path, explicit := configPath(flagValue)
+	cfg := &config{Profiles: map[string]*profile{}}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]*profile{}
+	}
+	return cfg, nil
+}
+
+// profileNames returns the sorted names of all profiles
+func (cfg *config) profileNames() []string {
+	var names []string
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveProfile returns the selected profile with the global flags applied on top of it
+func (cfg *config) resolveProfile(opts globalOptions) (*profile, error) {
+	name := opts.profile
+	if name == "" {
+		name = cfg.DefaultProfile
+	}
+	p := &profile{}
+	if name != "" {
+		selected, ok := cfg.Profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("profile %s not found in the configuration file", name)
+		}
+		*p = *selected
+	}
+	if opts.endpoint != "" {
+		p.Endpoint = opts.endpoint
+	}
+	if opts.user != "" {
+		p.User = opts.user
+	}
+	if opts.password != "" {
+		p.Password = opts.password
+	}
+	if opts.insecure {
+		p.Insecure = true
+	}
+	if opts.basicAuth {
+		p.BasicAuth = true
+	}
+	if p.Endpoint == "" {
+		return nil, fmt.Errorf("no endpoint configured, use --endpoint or a profile")
+	}
+	return p, nil
+}
+
+// onefsCfg converts a profile into the configuration used by OnefsConn.Connect
+func (p *profile) onefsCfg() *papilite.OnefsCfg {
+	cfg := &papilite.OnefsCfg{
+		User:       p.User,
+		Password:   p.Password,
+		Endpoint:   p.Endpoint,
+		BypassCert: p.Insecure,
+	}
+	switch {
+	case p.Password != "":
+	case p.PasswordFile != "":
+		cfg.Credentials = papilite.FileCredentials{User: p.User, PasswordFile: p.PasswordFile}
+	case p.PasswordEnv != "":
+		cfg.Password = os.Getenv(p.PasswordEnv)
+	default:
+		cfg.Password = os.Getenv(passwordEnvVar)
+	}
+	if p.CACertFile != "" || p.ServerName != "" {
+		cfg.TLS = &papilite.TLSOptions{CACertFile: p.CACertFile, ServerName: p.ServerName}
+	}
+	if p.BasicAuth {
+		cfg.AuthMode = papilite.AuthModeBasic
+	}
+	return cfg
+}
@@ -0,0 +1,207 @@
+// Command papi is a command line tool for ad-hoc calls to the PowerScale OneFS API built on go-papi-lite.
+//
+// Usage:
+//
+//	papi [global flags] <command> [arguments]
+//
+// Commands:
+//
+//	get <path> [--query key=value]...                    Send a GET request and print the combined response
+//	raw <method> <path> [--body file] [--query key=value] Send a request with any method
+//	users list [--zone name]                             List the users of an access zone
+//	users get <name> [--zone name]                       Show a single user
+//	zones list                                           List the access zones
+//	s3 key rotate <user> [--zone name] [--ttl minutes]   Create a new S3 key for a user
+//	profiles list                                        List the profiles in the configuration file
+//
+// Paths passed to get and raw are relative to the endpoint, e.g. platform/latest or platform/12/quota/quotas.
+// Responses that contain resume tokens are automatically combined into a single result.
+//
+// Connection settings are read from a profile in the configuration file and can be overridden with flags. The
+// configuration file defaults to ~/.papi.json or the value of the PAPI_CONFIG environment variable:
+//
+//	{
+//	  "default_profile": "prod",
+//	  "profiles": {
+//	    "prod": {
+//	      "endpoint": "https://cluster.fqdn:8080",
+//	      "user": "api_user",
+//	      "password_env": "PAPI_PASSWORD",
+//	      "ca_cert_file": "/etc/ssl/cluster-ca.pem"
+//	    }
+//	  }
+//	}
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	papilite "github.com/murkyl/go-papi-lite"
+)
+
+const usage string = `Usage: papi [global flags] <command> [arguments]
+
+Commands:
+  get <path> [--query key=value]...
+  raw <method> <path> [--body file] [--query key=value]...
+  users list [--zone name]
+  users get <name> [--zone name]
+  zones list
+  s3 key rotate <user> [--zone name] [--ttl minutes]
+  profiles list
+
+Global flags:
+`
+
+// globalOptions contains the values of the global flags
+type globalOptions struct {
+	configPath string
+	profile    string
+	endpoint   string
+	user       string
+	password   string
+	insecure   bool
+	basicAuth  bool
+	output     string
+	outputSet  bool
+	timeout    time.Duration
+	debug      bool
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command line in args and returns the exit code
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	var opts globalOptions
+	fs := flag.NewFlagSet("papi", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.configPath, "config", "", "Configuration file (default $PAPI_CONFIG or ~/.papi.json)")
+	fs.StringVar(&opts.profile, "profile", "", "Profile to use from the configuration file")
+	fs.StringVar(&opts.endpoint, "endpoint", "", "Endpoint URL, e.g. https://cluster.fqdn:8080")
+	fs.StringVar(&opts.user, "user", "", "User name")
+	fs.StringVar(&opts.password, "password", "", "Password (default $PAPI_PASSWORD)")
+	fs.BoolVar(&opts.insecure, "insecure", false, "Do not verify the endpoint certificate")
+	fs.BoolVar(&opts.basicAuth, "basic-auth", false, "Use HTTP basic authentication instead of a session")
+	fs.StringVar(&opts.output, "output", "json", "Output format: json, yaml or table")
+	fs.DurationVar(&opts.timeout, "timeout", 0, "Timeout for the whole command, e.g. 30s")
+	fs.BoolVar(&opts.debug, "debug", false, "Log requests and responses to stderr")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "output" {
+			opts.outputSet = true
+		}
+	})
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	cli := &cli{opts: opts, stdout: stdout, stderr: stderr}
+	err := cli.dispatch(ctx, fs.Args())
+	if err != nil {
+		var usageErr usageError
+		if errors.As(err, &usageErr) {
+			fmt.Fprintf(stderr, "papi: %s\n\n", err)
+			fs.Usage()
+			return 2
+		}
+		fmt.Fprintf(stderr, "papi: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// usageError is returned for invalid command lines
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// queryFlag collects repeated --query key=value flags
+type queryFlag map[string]string
+
+func (q queryFlag) String() string {
+	var items []string
+	for k, v := range q {
+		items = append(items, k+"="+v)
+	}
+	return strings.Join(items, ",")
+}
+
+func (q queryFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("query argument must be in the form key=value: %s", value)
+	}
+	q[kv[0]] = kv[1]
+	return nil
+}
+
+// parseArgs parses flags that may appear before, between or after positional arguments and returns the positional
+// arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, usageError(err.Error())
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// newFlagSet creates a FlagSet for a sub command that reports errors instead of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// stderrLogger is a papilite.Logger writing all messages to stderr
+type stderrLogger struct {
+	w io.Writer
+}
+
+func (l stderrLogger) log(level string, msg string, args []interface{}) {
+	fmt.Fprintf(l.w, "%s %s", level, msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(l.w, " %v=%v", args[i], args[i+1])
+	}
+	fmt.Fprintln(l.w)
+}
+
+func (l stderrLogger) Debug(msg string, args ...interface{}) { l.log("DEBUG", msg, args) }
+func (l stderrLogger) Info(msg string, args ...interface{})  { l.log("INFO", msg, args) }
+func (l stderrLogger) Warn(msg string, args ...interface{})  { l.log("WARN", msg, args) }
+func (l stderrLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args) }
+
+// Ensure the logger satisfies the library interface
+var _ papilite.Logger = stderrLogger{}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/murkyl/go-papi-lite/papitest"
+)
+
+// runCLI runs the command line against a profile pointing to srv and returns the exit code, stdout and stderr
+func runCLI(t *testing.T, srv *papitest.Server, args ...string) (int, string, string) {
+	t.Helper()
+	cfg := config{
+		DefaultProfile: "fake",
+		Profiles: map[string]*profile{
+			"fake": {Endpoint: srv.URL, User: papitest.DefaultUser, Password: papitest.DefaultPassword},
+		},
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "papi.json")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), append([]string{"--config", path}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLI(t *testing.T) {
+	srv := papitest.NewServer()
+	defer srv.Close()
+	srv.SetPageSize(2)
+	for _, name := range []string{"user1", "user2", "user3"} {
+		srv.AddUser(papitest.SystemZone, papitest.User{Name: name, Enabled: true})
+	}
+	bodyFile := filepath.Join(t.TempDir(), "body.json")
+	if err := ioutil.WriteFile(bodyFile, []byte(`{"name":"user4"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		code     int
+		contains []string
+	}{
+		{"get json", []string{"get", "platform/latest"}, 0, []string{`"latest": "` + papitest.DefaultLatestVersion + `"`}},
+		{"get yaml", []string{"--output", "yaml", "get", "platform/latest"}, 0, []string{`latest: "` + papitest.DefaultLatestVersion + `"`}},
+		{"raw post", []string{"raw", "POST", "platform/1/auth/users", "--body", bodyFile}, 0, nil},
+		{"users table", []string{"--output", "table", "users", "list", "--zone", "System"}, 0, []string{"NAME", "user1", "user3", "user4"}},
+		{"paginated get", []string{"get", "platform/1/auth/users", "--query", "zone=System"}, 0, []string{"user1", "user2", "user3"}},
+		{"unknown user", []string{"users", "get", "nobody"}, 1, nil},
+		{"unknown command", []string{"nodes", "list"}, 2, nil},
+		{"profiles", []string{"--output", "table", "profiles", "list"}, 0, []string{"fake", srv.URL, "true"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code, stdout, stderr := runCLI(t, srv, tc.args...)
+			if code != tc.code {
+				t.Fatalf("Exit code %d, expected %d. stderr: %s", code, tc.code, stderr)
+			}
+			for _, s := range tc.contains {
+				if !strings.Contains(stdout, s) {
+					t.Errorf("Output does not contain %q:\n%s", s, stdout)
+				}
+			}
+		})
+	}
+}
+
+func TestYAMLOutput(t *testing.T) {
+	var buf bytes.Buffer
+	p := &printer{format: outputYAML, w: &buf}
+	err := p.print(map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"name": "a", "id": 1},
+			"yes",
+		},
+		"empty": []interface{}{},
+		"path":  "/ifs/data",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "empty: []\nitems:\n  - id: 1\n    name: a\n  - \"yes\"\npath: /ifs/data\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected YAML output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	outputJSON  string = "json"
+	outputYAML  string = "yaml"
+	outputTable string = "table"
+)
+
+// printer writes command results in the selected output format
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+// newPrinter validates the output format and returns a printer for it
+func newPrinter(format string, w io.Writer) (*printer, error) {
+	switch format {
+	case outputJSON, outputYAML, outputTable:
+		return &printer{format: format, w: w}, nil
+	}
+	return nil, usageError(fmt.Sprintf("unknown output format %s, expected json, yaml or table", format))
+}
+
+// print writes a value. columns select the table columns and are ignored for other formats. When no columns are given
+// all keys with scalar values are used
+func (p *printer) print(value interface{}, columns ...string) error {
+	obj, err := normalize(value)
+	if err != nil {
+		return err
+	}
+	switch p.format {
+	case outputYAML:
+		var buf bytes.Buffer
+		writeYAML(&buf, obj, 0)
+		_, err = p.w.Write(buf.Bytes())
+		return err
+	case outputTable:
+		return writeTable(p.w, obj, columns)
+	}
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", data)
+	return err
+}
+
+// normalize converts typed results into the generic JSON representation so every output format works on the same
+// maps, slices and scalars
+func normalize(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var obj interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// writeYAML writes a normalized JSON value as a YAML block at the given indentation level
+func writeYAML(buf *bytes.Buffer, obj interface{}, indent int) {
+	pad := strings.Repeat("  ", indent)
+	switch val := obj.(type) {
+	case map[string]interface{}:
+		if len(val) == 0 {
+			buf.WriteString(pad + "{}\n")
+			return
+		}
+		for _, k := range sortedKeys(val) {
+			buf.WriteString(pad + yamlScalar(k) + ":")
+			writeYAMLValue(buf, val[k], indent+1)
+		}
+	case []interface{}:
+		if len(val) == 0 {
+			buf.WriteString(pad + "[]\n")
+			return
+		}
+		for _, item := range val {
+			buf.WriteString(pad + "-")
+			if m, ok := item.(map[string]interface{}); ok && len(m) > 0 {
+				// The first key of a mapping is written on the same line as the dash
+				var inner bytes.Buffer
+				writeYAML(&inner, m, indent+1)
+				buf.WriteString(" " + strings.TrimPrefix(inner.String(), pad+"  "))
+				continue
+			}
+			writeYAMLValue(buf, item, indent+1)
+		}
+	default:
+		buf.WriteString(pad + yamlScalar(val) + "\n")
+	}
+}
+
+// writeYAMLValue writes the value following a key or dash. Non empty collections start on a new line
+func writeYAMLValue(buf *bytes.Buffer, obj interface{}, indent int) {
+	switch val := obj.(type) {
+	case map[string]interface{}:
+		if len(val) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+	case []interface{}:
+		if len(val) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+	default:
+		buf.WriteString(" " + yamlScalar(val) + "\n")
+		return
+	}
+	buf.WriteString("\n")
+	writeYAML(buf, obj, indent)
+}
+
+// yamlScalar formats a scalar value. Strings are quoted when they would otherwise be read as another type or break the
+// YAML syntax
+func yamlScalar(obj interface{}) string {
+	switch val := obj.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(val)
+	case json.Number:
+		return val.String()
+	case string:
+		if yamlNeedsQuotes(val) {
+			return strconv.Quote(val)
+		}
+		return val
+	}
+	return fmt.Sprintf("%v", obj)
+}
+
+// yamlNeedsQuotes returns true if a string cannot be written as a plain YAML scalar
+func yamlNeedsQuotes(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return true
+	}
+	switch strings.ToLower(s) {
+	case "null", "~", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return true
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
+	if strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return true
+	}
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.ContainsAny(s, "\n\r\t") {
+		return true
+	}
+	return false
+}
+
+// writeTable writes a list of objects as a table. A single object is written as a key and value table and an object
+// with exactly one list, like most PAPI list responses, is written as a table of that list
+func writeTable(w io.Writer, obj interface{}, columns []string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	rows, ok := tableRows(obj)
+	if !ok {
+		m, isMap := obj.(map[string]interface{})
+		if !isMap {
+			fmt.Fprintln(tw, tableCell(obj))
+			return tw.Flush()
+		}
+		fmt.Fprintln(tw, "KEY\tVALUE")
+		for _, k := range sortedKeys(m) {
+			fmt.Fprintf(tw, "%s\t%s\n", k, tableCell(m[k]))
+		}
+		return tw.Flush()
+	}
+	if len(columns) == 0 {
+		columns = scalarColumns(rows)
+	}
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = strings.ToUpper(col)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(columns))
+		for i, col := range columns {
+			cells[i] = tableCell(lookupColumn(row, col))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// tableRows returns the objects to write as table rows
+func tableRows(obj interface{}) ([]map[string]interface{}, bool) {
+	if m, ok := obj.(map[string]interface{}); ok {
+		var list interface{}
+		for k, v := range m {
+			if _, isList := v.([]interface{}); !isList || k == "total" {
+				continue
+			}
+			if list != nil {
+				return nil, false
+			}
+			list = v
+		}
+		if list == nil {
+			return nil, false
+		}
+		obj = list
+	}
+	items, ok := obj.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	rows := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		row, isMap := item.(map[string]interface{})
+		if !isMap {
+			row = map[string]interface{}{"value": item}
+		}
+		rows = append(rows, row)
+	}
+	return rows, true
+}
+
+// scalarColumns returns the sorted keys that have a scalar value in at least one row
+func scalarColumns(rows []map[string]interface{}) []string {
+	seen := map[string]bool{}
+	var columns []string
+	for _, row := range rows {
+		for k, v := range row {
+			switch v.(type) {
+			case map[string]interface{}, []interface{}:
+				continue
+			}
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// lookupColumn returns the value for a column. Nested values are addressed with dots, e.g. primary_group.name
+func lookupColumn(row map[string]interface{}, column string) interface{} {
+	var value interface{} = row
+	for _, key := range strings.Split(column, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// tableCell formats a value for a table cell. Nested values are written as compact JSON
+func tableCell(obj interface{}) string {
+	switch val := obj.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprintf("%v", val)
+		}
+		return string(data)
+	}
+	return yamlScalar(obj)
+}
+
+// sortedKeys returns the keys of a map in sorted order so output is stable
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
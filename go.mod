@@ -1,5 +1,3 @@
 module github.com/murkyl/go-papi-lite
 
 go 1.16
-
-require github.com/mitchellh/mapstructure v1.4.1
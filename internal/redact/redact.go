@@ -0,0 +1,36 @@
+// Package redact replaces credentials and secrets in decoded JSON values. It is shared by the debug logging of the
+// library and the fixture recorder of papitest so both redact the same keys.
+package redact
+
+import (
+	"strings"
+)
+
+// Value replaces the value of a sensitive key
+const Value string = "REDACTED"
+
+// IsSensitiveKey returns true for JSON keys that contain credentials or secrets
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "password") || strings.Contains(key, "secret") || strings.Contains(key, "token")
+}
+
+// JSON recursively replaces the values of sensitive keys in a decoded JSON object in place and returns the object.
+// Only string values are replaced so flags and timestamps like password_expires are kept
+func JSON(obj interface{}) interface{} {
+	switch val := obj.(type) {
+	case map[string]interface{}:
+		for k, v := range val {
+			if _, ok := v.(string); ok && IsSensitiveKey(k) {
+				val[k] = Value
+			} else {
+				val[k] = JSON(v)
+			}
+		}
+	case []interface{}:
+		for i, v := range val {
+			val[i] = JSON(v)
+		}
+	}
+	return obj
+}
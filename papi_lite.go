@@ -33,7 +33,7 @@
 //
 // Wrapper code
 //
-// The wrapper code provides automatic parsing of responses from PAPI. The parsing of the JSON response relies on an external library. The data structures that contain the data are detailed in the papi_wrapper.go file.
+// The wrapper code provides automatic parsing of responses from PAPI. The JSON responses are decoded with the standard encoding/json package. The data structures that contain the data are detailed in the papi_wrapper.go file.
 // There are a limited number of wrapper calls available and the calls are split into the main functional sections of the API.
 //
 // Example
@@ -64,21 +64,26 @@
 // 	}
 // 	conn.Disconnect()
 //
+// Testing
+//
+// The papitest package provides an in-process fake PAPI server that can be used to test code using this library without a live cluster.
+//
 package papilite
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -87,19 +92,69 @@ const (
 	defaultMaxReauthCount int    = 1
 	sessionPath           string = "session/1/session"
 	maxCount              int    = 10000
+	// ArrayResponseKey is the key under which Send returns the elements of a response that is a JSON array instead of
+	// a JSON object
+	ArrayResponseKey   string = "items"
+	defaultDialTimeout        = 30 * time.Second
+)
+
+// AuthMode selects how a PapiSession authenticates its requests
+type AuthMode int
+
+const (
+	// AuthModeSession creates a session with the session service and sends the session cookie and CSRF token with
+	// every request. This is the default
+	AuthModeSession AuthMode = iota
+	// AuthModeBasic sends HTTP basic authentication credentials with every request. No session is created so there
+	// is nothing to tear down when the caller is done
+	AuthModeBasic
 )
 
 // PapiSession represents the state object for a connection
+// A connected PapiSession can be shared by multiple goroutines. The session state (SessionToken, CsrfToken and Client)
+// is protected by an internal lock and an expired session is renewed only once no matter how many concurrent requests
+// fail with a 401. The setters used to configure the session are not safe to call while requests are in flight.
 type PapiSession struct {
-	User         string
-	Password     string
-	Endpoint     string
-	IgnoreCert   bool
-	SessionToken string
-	CsrfToken    string
-	Client       *http.Client
-	ConnTimeout  int
-	reauthCount  int
+	User                string
+	Password            string
+	Endpoint            string
+	Endpoints           []string
+	FailoverCooldown    int
+	IgnoreCert          bool
+	TLS                 *TLSOptions
+	AuthMode            AuthMode
+	SessionToken        string
+	CsrfToken           string
+	Client              *http.Client
+	ConnTimeout         int
+	Transport           http.RoundTripper
+	ClientFactory       func() (*http.Client, error)
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     int
+	KeepAlive           int
+	RetryPolicy         *RetryPolicy
+	RateLimiter         *RateLimiter
+	InFlightLimiter     *InFlightLimiter
+	Logger              Logger
+	Credentials         CredentialProvider
+	mu                  sync.RWMutex
+	generation          uint64
+	authUser            string
+	authPassword        string
+	activeEndpoint      string
+	endpointDown        map[string]time.Time
+}
+
+// sessionState is a copy of the session values required to send a single request
+type sessionState struct {
+	client       *http.Client
+	sessionToken string
+	csrfToken    string
+	user         string
+	password     string
+	endpoint     string
+	// generation is incremented every time the session is (re)connected
+	generation uint64
 }
 
 // sessionRequest defines the parameters required in an HTTP POST body to create a session
@@ -157,6 +212,40 @@ func (ctx *PapiSession) SetIgnoreCert(b bool) bool {
 	return old
 }
 
+// SetCredentialProvider is a setter used to set a CredentialProvider. When a provider is set it is used instead of the
+// User and Password values every time the session authenticates
+func (ctx *PapiSession) SetCredentialProvider(p CredentialProvider) CredentialProvider {
+	old := ctx.Credentials
+	ctx.Credentials = p
+	return old
+}
+
+// credentials returns the user name and password from the CredentialProvider or the User and Password values if no
+// provider is set
+func (ctx *PapiSession) credentials(reqCtx context.Context) (string, string, error) {
+	if ctx.Credentials == nil {
+		return ctx.User, ctx.Password, nil
+	}
+	return ctx.Credentials.Credentials(reqCtx)
+}
+
+// SetAuthMode is a setter used to select between session cookie and HTTP basic authentication
+// If SetAuthMode is used after a connection has already been made you must disconnect and reconnect to use the new mode
+func (ctx *PapiSession) SetAuthMode(m AuthMode) AuthMode {
+	old := ctx.AuthMode
+	ctx.AuthMode = m
+	return old
+}
+
+// SetTLSOptions is a setter used to set the TLS options like custom CA certificates, client certificates and pinned
+// certificate fingerprints. If SetTLSOptions is used after a connection has already been made you must disconnect and
+// reconnect to use the new options
+func (ctx *PapiSession) SetTLSOptions(opts *TLSOptions) *TLSOptions {
+	old := ctx.TLS
+	ctx.TLS = opts
+	return old
+}
+
 // SetConnTimeout is a setter used to set the timeout for the HTTP connection (http.Client)
 func (ctx *PapiSession) SetConnTimeout(t int) int {
 	old := ctx.ConnTimeout
@@ -164,12 +253,83 @@ func (ctx *PapiSession) SetConnTimeout(t int) int {
 	return old
 }
 
+// SetRetryPolicy is a setter used to set the policy for retrying requests that fail with a transient error
+// A nil policy disables retries
+func (ctx *PapiSession) SetRetryPolicy(p *RetryPolicy) *RetryPolicy {
+	old := ctx.RetryPolicy
+	ctx.RetryPolicy = p
+	return old
+}
+
+// SetLogger is a setter used to set the Logger for all output of the session. A nil Logger restores the default
+// which writes warnings and errors with the standard log package
+func (ctx *PapiSession) SetLogger(l Logger) Logger {
+	old := ctx.Logger
+	ctx.Logger = l
+	return old
+}
+
+// logger returns the configured Logger or the default Logger if none was set
+func (ctx *PapiSession) logger() Logger {
+	if ctx.Logger == nil {
+		return stdLogger{}
+	}
+	return ctx.Logger
+}
+
+// SetTransport is a setter used to supply a custom http.RoundTripper, e.g. for proxies, tracing or tests. The transport
+// is kept across Connect, Disconnect and Reconnect. When a custom transport is used the IgnoreCert, TLS and connection
+// pool settings of the session are not applied
+func (ctx *PapiSession) SetTransport(t http.RoundTripper) http.RoundTripper {
+	old := ctx.Transport
+	ctx.Transport = t
+	return old
+}
+
+// SetClientFactory is a setter used to supply a function that creates the http.Client for the session. The factory
+// takes precedence over a Transport and is called every time the session connects
+func (ctx *PapiSession) SetClientFactory(f func() (*http.Client, error)) func() (*http.Client, error) {
+	old := ctx.ClientFactory
+	ctx.ClientFactory = f
+	return old
+}
+
+// SetMaxIdleConnsPerHost is a setter used to set the maximum number of idle connections kept open to the endpoint
+func (ctx *PapiSession) SetMaxIdleConnsPerHost(n int) int {
+	old := ctx.MaxIdleConnsPerHost
+	ctx.MaxIdleConnsPerHost = n
+	return old
+}
+
+// SetIdleConnTimeout is a setter used to set the time in seconds an idle connection is kept open
+func (ctx *PapiSession) SetIdleConnTimeout(t int) int {
+	old := ctx.IdleConnTimeout
+	ctx.IdleConnTimeout = t
+	return old
+}
+
+// SetKeepAlive is a setter used to set the TCP keep-alive period in seconds for connections to the endpoint
+func (ctx *PapiSession) SetKeepAlive(t int) int {
+	old := ctx.KeepAlive
+	ctx.KeepAlive = t
+	return old
+}
+
 // GetURL takes in a path and query argument to create a full URL based on the Endpoint
-// in the PapiSession.
+// in the PapiSession. After a failover the URL is based on the active endpoint instead.
 // path can be a string or a slice/array of strings
 // query is map of strings in a basic key, value pair
 func (ctx *PapiSession) GetURL(path interface{}, query map[string]string) string {
-	x, err := url.Parse(ctx.Endpoint)
+	endpoint := ctx.state().endpoint
+	if endpoint == "" {
+		endpoint = ctx.Endpoint
+	}
+	return endpointURL(endpoint, path, query)
+}
+
+// endpointURL creates a full URL from an endpoint, a path and query arguments
+func endpointURL(endpoint string, path interface{}, query map[string]string) string {
+	x, err := url.Parse(endpoint)
 	if err != nil {
 		return ""
 	}
@@ -189,46 +349,133 @@ func (ctx *PapiSession) GetURL(path interface{}, query map[string]string) string
 
 // init is an internal helper function to create the http.Client object
 func (ctx *PapiSession) init() error {
-	if ctx.IgnoreCert {
-		ctx.Client = &http.Client{
-			Timeout: time.Duration(ctx.ConnTimeout) * time.Second,
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true,
-				},
-			},
+	client, err := ctx.newClient()
+	if err != nil {
+		return err
+	}
+	ctx.Client = client
+	return nil
+}
+
+// newClient creates an http.Client from the client factory, transport or TLS and connection pool settings
+func (ctx *PapiSession) newClient() (*http.Client, error) {
+	if ctx.ClientFactory != nil {
+		client, err := ctx.ClientFactory()
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		ctx.Client = &http.Client{
-			Timeout: time.Duration(ctx.ConnTimeout) * time.Second,
+		if client == nil {
+			return nil, errors.New("[init] Client factory returned a nil http.Client")
 		}
+		return client, nil
 	}
-	return nil
+	transport := ctx.Transport
+	if transport == nil {
+		t, err := ctx.newTransport()
+		if err != nil {
+			return nil, err
+		}
+		transport = t
+	}
+	return &http.Client{
+		Timeout:   time.Duration(ctx.ConnTimeout) * time.Second,
+		Transport: transport,
+	}, nil
+}
+
+// newTransport builds an http.Transport from the TLS and connection pool settings of the session
+func (ctx *PapiSession) newTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ctx.IgnoreCert || ctx.TLS != nil {
+		tlsConfig, err := buildTLSConfig(ctx.TLS, ctx.IgnoreCert)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	if ctx.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = ctx.MaxIdleConnsPerHost
+	}
+	if ctx.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(ctx.IdleConnTimeout) * time.Second
+	}
+	if ctx.KeepAlive > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   defaultDialTimeout,
+			KeepAlive: time.Duration(ctx.KeepAlive) * time.Second,
+		}).DialContext
+	}
+	return transport, nil
 }
 
 // Connect is called to initiate a connection to the endpoint. Connect can be called multiple times as
 // the function will automatically disconnect any existing connection. Changes to the endpoint can be
 // made to the context and another Connect made to switch to the other endpoint.
 func (ctx *PapiSession) Connect() error {
-	var match []string
-	// Regular expressions to pull the isisessid and isicsrf fields out of the Cookie header in the session response
-	rexSession := regexp.MustCompile(`.*isisessid=(?P<session>[^;]+).*`)
-	rexCsrf := regexp.MustCompile(`.*isicsrf=(?P<csrf>[^;]+).*`)
+	return ctx.ConnectContext(context.Background())
+}
+
+// ConnectContext is the same as Connect but the session requests are bound to the passed in context.Context
+func (ctx *PapiSession) ConnectContext(reqCtx context.Context) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.connect(reqCtx)
+}
 
+// connect performs the actual session creation. When additional Endpoints are configured they are tried in order
+// until a session is created on a reachable endpoint. The caller must hold the session lock
+func (ctx *PapiSession) connect(reqCtx context.Context) error {
 	// Cleanup any existing session before trying to connect
-	ctx.Disconnect()
+	ctx.disconnect(reqCtx)
 	// Automatically initialize the PapiSession if it is not already initialized
 	if ctx.Client == nil {
-		ctx.init()
+		if err := ctx.init(); err != nil {
+			return fmt.Errorf("[Connect] Unable to initialize the HTTP client: %w", err)
+		}
+	}
+	ctx.generation++
+	user, password, err := ctx.credentials(reqCtx)
+	if err != nil {
+		return fmt.Errorf("[Connect] Unable to get credentials: %w", err)
+	}
+	candidates := ctx.endpointCandidates()
+	if len(candidates) == 0 {
+		return errors.New("[Connect] No endpoint configured")
+	}
+	// With basic authentication the credentials are sent with every request so there is no session to create
+	if ctx.AuthMode == AuthModeBasic {
+		ctx.activeEndpoint = candidates[0]
+		ctx.authUser = user
+		ctx.authPassword = password
+		return nil
+	}
+	for i, endpoint := range candidates {
+		ctx.activeEndpoint = endpoint
+		err = ctx.createSession(reqCtx, user, password)
+		if err == nil || i == len(candidates)-1 || reqCtx.Err() != nil || !isConnectionError(err) {
+			break
+		}
+		ctx.markEndpointDown(endpoint)
+		ctx.logger().Warn("[Connect] Endpoint unreachable, trying next endpoint", "endpoint", endpoint, "error", err)
 	}
+	return err
+}
+
+// createSession creates a session on the active endpoint. The caller must hold the session lock
+func (ctx *PapiSession) createSession(reqCtx context.Context, user string, password string) error {
+	var match []string
+	// Regular expressions to pull the isisessid and isicsrf fields out of the Cookie header in the session response
+	rexSession := regexp.MustCompile(`.*isisessid=(?P<session>[^;]+).*`)
+	rexCsrf := regexp.MustCompile(`.*isicsrf=(?P<csrf>[^;]+).*`)
 
 	body := sessionRequest{
-		Username: ctx.User,
-		Password: ctx.Password,
+		Username: user,
+		Password: password,
 		Services: []string{"platform", "namespace"},
 	}
 	jsonBody, _ := json.Marshal(body)
-	req, err := http.NewRequest("POST", ctx.GetURL(sessionPath, nil), bytes.NewReader(jsonBody))
+	ctx.logger().Debug("[Connect] Creating session", "endpoint", ctx.activeEndpoint, "user", user)
+	req, err := http.NewRequestWithContext(reqCtx, "POST", endpointURL(ctx.activeEndpoint, sessionPath, nil), bytes.NewReader(jsonBody))
 	if err != nil {
 		return fmt.Errorf("[Connect] Failed to create NewRequest: %v", err)
 	}
@@ -236,12 +483,12 @@ func (ctx *PapiSession) Connect() error {
 	req.Header.Add("Accept", "application/json")
 	resp, err := ctx.Client.Do(req)
 	if err != nil {
-		return fmt.Errorf("[Connect] Client.Do error: %v", err)
+		return fmt.Errorf("[Connect] Client.Do error: %w", err)
 	}
 	defer resp.Body.Close()
 	respBody, _ := ioutil.ReadAll(resp.Body)
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("[Connect] Unable to create a session: %s", fmt.Sprintf("%+v", string(respBody)))
+		return fmt.Errorf("[Connect] Unable to create a session: %w", newPapiError(resp, respBody))
 	}
 	sessionID := resp.Header["Set-Cookie"]
 	for i := 0; i < len(sessionID); i++ {
@@ -262,135 +509,243 @@ func (ctx *PapiSession) Connect() error {
 	if ctx.CsrfToken == "" {
 		return errors.New("[Connect] No CSRF token found in API connect call")
 	}
-	ctx.reauthCount = 0
 	return nil
 }
 
 // Disconnect cleans up a connection to an endpoint. This should be called after calls to the API are completed
 func (ctx *PapiSession) Disconnect() error {
+	return ctx.DisconnectContext(context.Background())
+}
+
+// DisconnectContext is the same as Disconnect but the session delete request is bound to the passed in context.Context
+func (ctx *PapiSession) DisconnectContext(reqCtx context.Context) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.disconnect(reqCtx)
+}
+
+// disconnect performs the actual session delete. The caller must hold the session lock
+func (ctx *PapiSession) disconnect(reqCtx context.Context) error {
 	if ctx.Client == nil {
 		return nil
 	}
-	req, err := http.NewRequest("DELETE", ctx.GetURL(sessionPath, nil), nil)
+	if ctx.AuthMode == AuthModeBasic {
+		ctx.reset()
+		return nil
+	}
+	req, err := http.NewRequestWithContext(reqCtx, "DELETE", endpointURL(ctx.activeEndpoint, sessionPath, nil), nil)
 	if err != nil {
 		return fmt.Errorf("[Disconnect] Failed to crate NewRequest: %v", err)
 	}
-	setHeaders(req, ctx, nil)
-	_, err = ctx.Client.Do(req)
+	setHeaders(req, ctx, ctx.stateLocked(), nil)
+	resp, err := ctx.Client.Do(req)
 	if err != nil {
 		err = fmt.Errorf("[Disconnect] Session delete error: %v", err)
+	} else {
+		discardResponse(resp)
+	}
+	ctx.reset()
+	// This return takes the error code from the Client.Do above and returns it. Successful runs will return nil
+	return err
+}
+
+// reset drops the local session state without contacting the endpoint. The caller must hold the session lock
+func (ctx *PapiSession) reset() {
+	if ctx.Client != nil {
+		ctx.Client.CloseIdleConnections()
 	}
-	ctx.Client.CloseIdleConnections()
 	ctx.Client = nil
 	ctx.SessionToken = ""
 	ctx.CsrfToken = ""
-	// This return takes the error code from the Client.Do above and returns it. Successful runs will return nil
-	return err
+	ctx.authUser = ""
+	ctx.authPassword = ""
 }
 
 // Reconnect is a simple helper function that calls Disconnect and then Connect in succession
 func (ctx *PapiSession) Reconnect() error {
-	ctx.Disconnect()
-	return ctx.Connect()
+	return ctx.ReconnectContext(context.Background())
+}
+
+// ReconnectContext is the same as Reconnect but the session requests are bound to the passed in context.Context
+func (ctx *PapiSession) ReconnectContext(reqCtx context.Context) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.disconnect(reqCtx)
+	return ctx.connect(reqCtx)
+}
+
+// state returns a consistent copy of the values of the current session
+func (ctx *PapiSession) state() sessionState {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	return ctx.stateLocked()
+}
+
+// stateLocked is the same as state but the caller must already hold the session lock
+func (ctx *PapiSession) stateLocked() sessionState {
+	return sessionState{
+		client:       ctx.Client,
+		sessionToken: ctx.SessionToken,
+		csrfToken:    ctx.CsrfToken,
+		user:         ctx.authUser,
+		password:     ctx.authPassword,
+		endpoint:     ctx.activeEndpoint,
+		generation:   ctx.generation,
+	}
+}
+
+// renewSession re-authenticates a session that was rejected by the endpoint. staleGeneration is the session generation
+// that was used by the rejected request. When multiple requests fail at the same time only the first one to acquire the
+// lock reconnects. All others wait for the lock and find the session already replaced so they can simply retry.
+// Reconnecting fetches the credentials again so a rotated password is picked up.
+func (ctx *PapiSession) renewSession(reqCtx context.Context, staleGeneration uint64) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.Client != nil && ctx.generation != staleGeneration {
+		return nil
+	}
+	ctx.disconnect(reqCtx)
+	return ctx.connect(reqCtx)
 }
 
 // SendRaw makes a call to the API and returns the raw HTTP response and error codes. It is the responsibility
 // of the caller to process the response.
 func (ctx *PapiSession) SendRaw(method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) (*http.Response, error) {
-	var reqBody io.Reader
-	switch body.(type) {
-	case nil:
-		reqBody = nil
-	case []byte:
-		reqBody = bytes.NewReader(body.([]byte))
-	case string:
-		reqBody = bytes.NewReader([]byte(body.(string)))
-	default:
-		reqBody = bytes.NewReader([]byte(body.(string)))
-	}
-	req, err := http.NewRequest(method, ctx.GetURL(path, query), reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("[SendRaw] Request error: %v", err)
-	}
-	setHeaders(req, ctx, headers)
-	return ctx.Client.Do(req)
+	return ctx.SendRawContext(context.Background(), method, path, query, body, headers)
 }
 
-// Send performs an API call and does some automatic post-processing. This processing consists of converting the
-// response into a JSON object in the form of a map[string]interface{}. Any resume keys are automatically handled
-// and the result is combined such that all values are returned in a single object. This may be a problem for very
-// large data sets. In those situations use SendRaw as an alternative.
-func (ctx *PapiSession) Send(method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) (map[string]interface{}, error) {
-	jsonBody := make(map[string]interface{})
-	var jsonTemp map[string]interface{}
-	var resumeKey string
-	var rkey interface{}
-
-	// The count variable puts an upper limit on the number of times this function will automatically fetch additional data
-	for resume, count := true, 0; resume && count < maxCount; count++ {
-		if resumeKey != "" {
-			// When a resume key is used all old query parameters should be discarded and only the resume key in the query arguments list
-			query = map[string]string{"resume": resumeKey}
+// SendRawContext is the same as SendRaw but the request is bound to the passed in context.Context. Cancelling the
+// context aborts the request
+// Requests that fail with a transient error are retried according to the RetryPolicy of the session. If all attempts
+// fail with a retryable status code the response of the last attempt is returned.
+// Every request, including retries, waits for the RateLimiter and InFlightLimiter of the session. When the cluster
+// responds with 429 or 503 and a Retry-After header, the next retry waits at least as long as requested.
+// body can be nil, a []byte or string containing JSON, an io.Reader or any other value that is marshaled to JSON. A
+// reader is streamed to the endpoint. Unless the reader also implements io.Seeker it can only be sent once so the
+// request is not retried, failed over or re-authenticated.
+// When additional Endpoints are configured and the active endpoint cannot be reached, the session is re-established
+// on the next reachable endpoint and the request is sent again. Requests with a non idempotent method are only sent
+// again if the connection to the failed endpoint could not be established.
+func (ctx *PapiSession) SendRawContext(reqCtx context.Context, method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) (*http.Response, error) {
+	logger := ctx.logger()
+	inFlight := ctx.InFlightLimiter
+	reqBody, err := newRequestBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("[SendRaw] Unable to marshal request body: %w", err)
+	}
+	logBody := redactedBody(reqBody.data)
+	failovers := 0
+	for attempt := 1; ; attempt++ {
+		if err := ctx.RateLimiter.Wait(reqCtx); err != nil {
+			return nil, fmt.Errorf("[SendRaw] Request aborted while waiting for the rate limiter: %w", err)
+		}
+		if err := inFlight.Acquire(reqCtx); err != nil {
+			return nil, fmt.Errorf("[SendRaw] Request aborted while waiting for a free request slot: %w", err)
 		}
-		resp, err := ctx.SendRaw(method, path, query, body, headers)
+		state := ctx.state()
+		if state.client == nil {
+			inFlight.Release()
+			return nil, errors.New("[SendRaw] Session is not connected")
+		}
+		bodyReader, bodyLength, err := reqBody.open()
 		if err != nil {
-			return nil, fmt.Errorf("[Send] Error returned by SendRaw: %v", err)
+			inFlight.Release()
+			return nil, fmt.Errorf("[SendRaw] Unable to read request body: %w", err)
 		}
-		defer resp.Body.Close()
-		rawBody, err := ioutil.ReadAll(resp.Body)
+		req, err := http.NewRequestWithContext(reqCtx, method, endpointURL(state.endpoint, path, query), bodyReader)
 		if err != nil {
-			return nil, fmt.Errorf("[Send] Error reading response body: %v", err)
+			inFlight.Release()
+			return nil, fmt.Errorf("[SendRaw] Request error: %v", err)
 		}
-		if resp.StatusCode < 200 || resp.StatusCode > 299 {
-			if resp.StatusCode == 401 {
-				// If a 401 error with a message of "Authorization required" is received, we should automatically re-authenticate to get a new session token and retry the request
-				if ctx.reauthCount >= defaultMaxReauthCount {
-					log.Printf("[ERROR][Send] Automatic re-authentication failed!")
-				} else {
-					ctx.reauthCount++
-					ctx.Reconnect()
-					// Recursively call Send with the same parameters and return the result. There is a limited number of re-auth attempts before failing the entire call
-					return ctx.Send(method, path, query, body, headers)
+		if bodyLength > 0 {
+			req.ContentLength = bodyLength
+		}
+		setHeaders(req, ctx, state, headers)
+		logger.Debug("[SendRaw] Request", "method", method, "url", req.URL.String(), "headers", redactedHeader(req.Header), "body", logBody)
+		resp, err := state.client.Do(req)
+		if err != nil {
+			inFlight.Release()
+			logger.Debug("[SendRaw] Request failed", "method", method, "url", req.URL.String(), "error", err)
+			endpoints := ctx.endpointCount()
+			if failovers < endpoints-1 && reqBody.replayable() && canFailover(method, endpoints, err) {
+				failovers++
+				if ferr := ctx.failover(reqCtx, state.generation); ferr != nil {
+					return nil, fmt.Errorf("[SendRaw] Failover after connection error failed: %w", ferr)
 				}
+				// A request moved to another endpoint does not count as a retry attempt
+				attempt--
+				continue
+			}
+		} else {
+			logger.Debug("[SendRaw] Response", "method", method, "url", req.URL.String(), "status", resp.StatusCode, "headers", redactedHeader(resp.Header))
+			if inFlight != nil {
+				resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: inFlight.Release}
 			}
-			return nil, fmt.Errorf("[Send] Non 2xx response received (%d): %s", resp.StatusCode, fmt.Sprintf("%+v", string(rawBody)))
 		}
-
-		// If there is no body in the response, there is no need to try and process continuation requests
-		// This can happen for some methods like DELETE
-		if len(rawBody) == 0 || rawBody == nil {
-			return nil, nil
+		// A throttled cluster can ask for a minimum delay. This pauses every request sharing the rate limiter and
+		// replaces a shorter backoff delay. The delay is capped so a bad header cannot block requests indefinitely
+		var throttleDelay time.Duration
+		if throttled(resp) {
+			if d, ok := retryAfter(resp, time.Now()); ok {
+				if max := ctx.RetryPolicy.maxBackoff(); d > max {
+					d = max
+				}
+				throttleDelay = d
+				ctx.RateLimiter.pause(time.Now().Add(d))
+			}
 		}
-
-		err = json.Unmarshal(rawBody, &jsonTemp)
-		if err != nil {
-			return nil, fmt.Errorf("[Send] Error unmarshaling JSON: %v", err)
+		if !reqBody.replayable() || !ctx.RetryPolicy.retry(method, attempt, resp, err) {
+			return resp, err
 		}
-		rkey, resume = jsonTemp["resume"]
-		if resume == true {
-			if rkey != nil {
-				resumeKey = rkey.(string)
-			} else {
-				resume = false
-			}
+		discardResponse(resp)
+		delay := ctx.RetryPolicy.backoff(attempt)
+		if throttleDelay > delay {
+			delay = throttleDelay
 		}
-		ekey, ok := jsonBody["errors"]
-		if ok == true {
-			return nil, fmt.Errorf("[Send] Response to Send request returned errors in JSON: %v", ekey)
+		logger.Warn("[SendRaw] Retrying request", "method", method, "url", req.URL.String(), "attempt", attempt, "delay", delay)
+		if err := sleepContext(reqCtx, delay); err != nil {
+			return nil, fmt.Errorf("[SendRaw] Request aborted while waiting to retry: %w", err)
 		}
+	}
+}
+
+// Send performs an API call and does some automatic post-processing. This processing consists of converting the
+// response into a JSON object in the form of a map[string]interface{}. Any resume keys are automatically handled
+// and the result is combined such that all values are returned in a single object. This may be a problem for very
+// large data sets. In those situations use a Pager from NewPager to process one page at a time or SendRaw as an
+// alternative.
+// A response that is a JSON array is returned as a map with the elements under ArrayResponseKey. A 204 response or a
+// response without a body returns a nil map and no error. Responses that are not JSON, like file downloads, should be
+// read with SendStream instead.
+func (ctx *PapiSession) Send(method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) (map[string]interface{}, error) {
+	return ctx.SendContext(context.Background(), method, path, query, body, headers)
+}
+
+// SendContext is the same as Send but all requests, including any automatic resume and re-authentication requests,
+// are bound to the passed in context.Context. The context is checked before every resume request so that a long
+// paginated call can be aborted between pages as well as in the middle of a request.
+func (ctx *PapiSession) SendContext(reqCtx context.Context, method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) (map[string]interface{}, error) {
+	var jsonBody map[string]interface{}
+
+	pager := ctx.NewPagerContext(reqCtx, method, path, query, body, headers)
+	for pager.Next() {
+		jsonTemp := pager.Page()
 		// Remove extraneous fields from the JSON response as they are only used with continued responses
-		delete(jsonTemp, "errors")
-		delete(jsonTemp, "resume")
 		delete(jsonTemp, "total")
+		if jsonBody == nil {
+			jsonBody = jsonTemp
+			continue
+		}
 		// Combine the jsonTemp with jsonBody
 		for key, dval := range jsonTemp {
 			sval, ok := jsonBody[key]
 			if ok == true {
 				switch sval.(type) {
 				case []interface{}:
-					// TODO: Use more efficient way to combine results
-					for _, v := range dval.([]interface{}) {
-						jsonBody[key] = append(jsonBody[key].([]interface{}), v)
+					if dslice, ok := dval.([]interface{}); ok {
+						jsonBody[key] = append(sval.([]interface{}), dslice...)
+					} else {
+						jsonBody[key] = dval
 					}
 				default:
 					jsonBody[key] = dval
@@ -400,25 +755,128 @@ func (ctx *PapiSession) Send(method string, path interface{}, query map[string]s
 			}
 		}
 	}
+	if err := pager.Err(); err != nil {
+		return nil, err
+	}
 	return jsonBody, nil
 }
 
+// sendPage performs a single API call and converts the response into a JSON object. Resume keys are not followed
+// but a request that fails with a 401 is automatically re-authenticated and retried. A nil map is returned when the
+// response has no body. A JSON array is returned in a map under ArrayResponseKey.
+func (ctx *PapiSession) sendPage(reqCtx context.Context, method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) (map[string]interface{}, error) {
+	var jsonTemp map[string]interface{}
+
+	rawBody, resp, err := ctx.sendPageRaw(reqCtx, method, path, query, body, headers)
+	if err != nil {
+		return nil, err
+	}
+	// If there is no body in the response, there is no need to try and process continuation requests
+	// This happens for 204 responses and for some methods like DELETE
+	rawBody = bytes.TrimSpace(rawBody)
+	if resp.StatusCode == http.StatusNoContent || len(rawBody) == 0 {
+		return nil, nil
+	}
+	if rawBody[0] == '[' {
+		var items []interface{}
+		if err := json.Unmarshal(rawBody, &items); err != nil {
+			return nil, fmt.Errorf("[Send] Error unmarshaling JSON: %v", err)
+		}
+		return map[string]interface{}{ArrayResponseKey: items}, nil
+	}
+	err = json.Unmarshal(rawBody, &jsonTemp)
+	if err != nil {
+		return nil, fmt.Errorf("[Send] Error unmarshaling JSON: %v", err)
+	}
+	if ekey, ok := jsonTemp["errors"].([]interface{}); ok && len(ekey) > 0 {
+		return nil, newPapiError(resp, rawBody)
+	}
+	return jsonTemp, nil
+}
+
+// sendPageRaw performs a single API call and returns the response body. A request that fails with a 401 is
+// automatically re-authenticated and retried. Responses with a status code outside of the 2xx range are returned as a
+// PapiError. The returned response can be used for error reporting but its body has already been read and closed.
+func (ctx *PapiSession) sendPageRaw(reqCtx context.Context, method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) ([]byte, *http.Response, error) {
+	resp, err := ctx.sendAuthenticated(reqCtx, method, path, query, body, headers)
+	if err != nil {
+		return nil, resp, err
+	}
+	rawBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("[Send] Error reading response body: %v", err)
+	}
+	ctx.logger().Debug("[Send] Response body", "status", resp.StatusCode, "body", redactedBody(rawBody))
+	return rawBody, resp, nil
+}
+
+// sendAuthenticated performs a single API call and returns the response with the body still open. A request that
+// fails with a 401 is automatically re-authenticated and retried. Responses with a status code outside of the 2xx range
+// are returned as a PapiError together with the response. The body of such a response has already been closed.
+func (ctx *PapiSession) sendAuthenticated(reqCtx context.Context, method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) (*http.Response, error) {
+	reqBody, err := newRequestBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("[Send] Unable to marshal request body: %w", err)
+	}
+	for reauthCount := 0; ; {
+		if err := reqCtx.Err(); err != nil {
+			return nil, fmt.Errorf("[Send] Request aborted: %w", err)
+		}
+		// Remember the session used for this request so a 401 response only renews the session if no other request
+		// has already done so
+		staleGeneration := ctx.state().generation
+		resp, err := ctx.SendRawContext(reqCtx, method, path, query, reqBody, headers)
+		if err != nil {
+			return nil, fmt.Errorf("[Send] Error returned by SendRaw: %w", err)
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return resp, nil
+		}
+		rawBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("[Send] Error reading response body: %v", err)
+		}
+		ctx.logger().Debug("[Send] Response body", "status", resp.StatusCode, "body", redactedBody(rawBody))
+		// A streamed body that cannot be rewound has already been consumed so the request cannot be sent again
+		if resp.StatusCode == 401 && reqBody.replayable() {
+			// If a 401 error with a message of "Authorization required" is received, we should automatically re-authenticate to get a new session token and retry the request
+			if reauthCount >= defaultMaxReauthCount {
+				ctx.logger().Error("[Send] Automatic re-authentication failed!", "method", method, "path", resp.Request.URL.Path)
+			} else {
+				reauthCount++
+				if err := ctx.renewSession(reqCtx, staleGeneration); err != nil {
+					ctx.logger().Warn("[Send] Unable to renew session", "error", err)
+				}
+				// Retry the request with the same parameters. There is a limited number of re-auth attempts before failing the entire call
+				continue
+			}
+		}
+		return resp, newPapiError(resp, rawBody)
+	}
+}
+
 // setHeaders sets the headers for a request appropriately
-// The function takes the request, PapiSession, and a map containing possible header key/value pairs
+// The function takes the request, PapiSession, a copy of the session state, and a map containing possible header key/value pairs
 // The function first overwrites any existing headers in the request with those supplied in the headers parameter
-// Only after this is done do we attempt to add in the session, CSRF and Referer headers. If these headers exist
+// Only after this is done do we attempt to add in the session, CSRF or basic authentication and Referer headers. If these headers exist
 // in the passed in headers array, they are not overridden. The values in the passed in headers map take precedence
-func setHeaders(req *http.Request, ctx *PapiSession, headers map[string]string) {
+func setHeaders(req *http.Request, ctx *PapiSession, state sessionState, headers map[string]string) {
 	for k, v := range headers {
 		// Manually set headers as we want to preserve the case sensitivity of each header
 		req.Header[k] = []string{v}
 	}
 	defaultHeaders := map[string]string{
 		"Accept":       "application/json",
-		"Cookie":       "isisessid=" + ctx.SessionToken,
 		"Content-Type": "application/json",
-		"Referer":      ctx.Endpoint,
-		"X-CSRF-Token": ctx.CsrfToken,
+		"Referer":      state.endpoint,
+	}
+	if ctx.AuthMode == AuthModeBasic {
+		defaultHeaders["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(state.user+":"+state.password))
+	} else {
+		defaultHeaders["Cookie"] = "isisessid=" + state.sessionToken
+		defaultHeaders["X-CSRF-Token"] = state.csrfToken
 	}
 	for k, v := range defaultHeaders {
 		if _, ok := req.Header[k]; !ok {
@@ -0,0 +1,80 @@
+package papilite
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// requestBody holds the body of a request so it can be sent again for retries, failover and re-authentication.
+// []byte and string bodies are sent as is, io.Reader bodies are streamed and any other value is marshaled to JSON.
+// A reader that also implements io.Seeker is rewound before every attempt. Any other reader can only be sent once.
+type requestBody struct {
+	data   []byte
+	reader io.Reader
+	seeker io.Seeker
+	offset int64
+	sent   bool
+}
+
+// newRequestBody prepares a body passed to one of the send functions. A *requestBody is returned unchanged so the
+// body of a request that is sent more than once is only prepared once
+func newRequestBody(body interface{}) (*requestBody, error) {
+	switch b := body.(type) {
+	case nil:
+		return &requestBody{}, nil
+	case *requestBody:
+		return b, nil
+	case []byte:
+		return &requestBody{data: b}, nil
+	case string:
+		return &requestBody{data: []byte(b)}, nil
+	case io.Reader:
+		rb := &requestBody{reader: b}
+		if seeker, ok := b.(io.Seeker); ok {
+			if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+				rb.seeker = seeker
+				rb.offset = offset
+			}
+		}
+		return rb, nil
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &requestBody{data: data}, nil
+}
+
+// replayable returns true if the body can be sent more than once
+func (b *requestBody) replayable() bool {
+	return b.reader == nil || b.seeker != nil
+}
+
+// open returns the reader and length of the body for the next attempt. A length of -1 means the length is unknown
+func (b *requestBody) open() (io.Reader, int64, error) {
+	if b.reader == nil {
+		if b.data == nil {
+			return nil, 0, nil
+		}
+		return bytes.NewReader(b.data), int64(len(b.data)), nil
+	}
+	if b.seeker != nil {
+		end, err := b.seeker.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = b.seeker.Seek(b.offset, io.SeekStart)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		// The HTTP client closes bodies that implement io.Closer, which would prevent sending the reader again
+		return ioutil.NopCloser(b.reader), end - b.offset, nil
+	}
+	if b.sent {
+		return nil, 0, errors.New("request body is a stream that has already been sent")
+	}
+	b.sent = true
+	return b.reader, -1, nil
+}
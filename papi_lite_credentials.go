@@ -0,0 +1,81 @@
+package papilite
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// CredentialProvider supplies the user name and password used to authenticate a PapiSession. The provider is called
+// every time a session is created, including automatic re-authentication after a session expires or a request is
+// rejected with a 401, so rotated credentials are picked up without having to rebuild the session.
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (user string, password string, err error)
+}
+
+// CredentialFunc adapts a function, e.g. a callback into a secret store, to the CredentialProvider interface
+type CredentialFunc func(ctx context.Context) (string, string, error)
+
+// Credentials calls the function
+func (f CredentialFunc) Credentials(ctx context.Context) (string, string, error) {
+	return f(ctx)
+}
+
+// StaticCredentials is a CredentialProvider that always returns the same user name and password
+type StaticCredentials struct {
+	User     string
+	Password string
+}
+
+// Credentials returns the static user name and password
+func (c StaticCredentials) Credentials(ctx context.Context) (string, string, error) {
+	return c.User, c.Password, nil
+}
+
+// EnvCredentials is a CredentialProvider that reads the user name and password from environment variables
+type EnvCredentials struct {
+	UserVar     string
+	PasswordVar string
+}
+
+// Credentials returns the current values of the environment variables. An error is returned if either is not set
+func (c EnvCredentials) Credentials(ctx context.Context) (string, string, error) {
+	user, ok := os.LookupEnv(c.UserVar)
+	if !ok {
+		return "", "", fmt.Errorf("[EnvCredentials] Environment variable %s is not set", c.UserVar)
+	}
+	password, ok := os.LookupEnv(c.PasswordVar)
+	if !ok {
+		return "", "", fmt.Errorf("[EnvCredentials] Environment variable %s is not set", c.PasswordVar)
+	}
+	return user, password, nil
+}
+
+// FileCredentials is a CredentialProvider that reads the password, and optionally the user name, from files. This
+// matches the way secrets are usually mounted into containers. The files are read on every call so a rotated password
+// is used the next time the session is renewed. Leading and trailing white space is removed from the file contents.
+type FileCredentials struct {
+	// User is used as the user name when UserFile is empty
+	User         string
+	UserFile     string
+	PasswordFile string
+}
+
+// Credentials returns the user name and password read from the files
+func (c FileCredentials) Credentials(ctx context.Context) (string, string, error) {
+	user := c.User
+	if c.UserFile != "" {
+		data, err := ioutil.ReadFile(c.UserFile)
+		if err != nil {
+			return "", "", fmt.Errorf("[FileCredentials] Unable to read user file: %w", err)
+		}
+		user = strings.TrimSpace(string(data))
+	}
+	data, err := ioutil.ReadFile(c.PasswordFile)
+	if err != nil {
+		return "", "", fmt.Errorf("[FileCredentials] Unable to read password file: %w", err)
+	}
+	return user, strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,101 @@
+package papilite
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// PapiError is returned when the API responds with a non 2xx status code or a response containing an errors list.
+// The errors returned by the API are decoded into the Errors field. Use errors.As to retrieve a PapiError from an
+// error returned by any of the calls in this library.
+type PapiError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Errors     []OnefsError
+	Body       string
+}
+
+// newPapiError creates a PapiError from an HTTP response and its already read body
+func newPapiError(resp *http.Response, body []byte) *PapiError {
+	apiErr := &PapiError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+	}
+	if resp.Request != nil {
+		apiErr.Method = resp.Request.Method
+		apiErr.Path = resp.Request.URL.Path
+	}
+	var result struct {
+		Errors []OnefsError `json:"errors"`
+	}
+	if json.Unmarshal(body, &result) == nil {
+		apiErr.Errors = result.Errors
+	}
+	return apiErr
+}
+
+// Error returns a string version of the error including the status code and the errors returned by the API
+func (e *PapiError) Error() string {
+	if len(e.Errors) > 0 {
+		return fmt.Sprintf("[Send] Non 2xx response received (%d) for %s %s: %+v", e.StatusCode, e.Method, e.Path, e.Errors)
+	}
+	return fmt.Sprintf("[Send] Non 2xx response received (%d) for %s %s: %s", e.StatusCode, e.Method, e.Path, e.Body)
+}
+
+// HasCode returns true if any of the errors returned by the API has the given code, e.g. AEC_CONFLICT
+func (e *PapiError) HasCode(code string) bool {
+	for _, apiErr := range e.Errors {
+		if apiErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// isPapiError is a helper that checks if err is a PapiError with either the given status code or the given API error code
+func isPapiError(err error, status int, code string) bool {
+	var apiErr *PapiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == status || apiErr.HasCode(code)
+}
+
+// emptyListError is returned by wrapper calls that expect at least one item in a list response but received none.
+// IsNotFound returns true for this error
+type emptyListError struct {
+	msg string
+}
+
+// Error returns the message of the error
+func (e *emptyListError) Error() string {
+	return e.msg
+}
+
+// IsNotFound returns true if err is a PapiError for a resource that does not exist or a wrapper call found no item in
+// the response of the API
+func IsNotFound(err error) bool {
+	var empty *emptyListError
+	if errors.As(err, &empty) {
+		return true
+	}
+	return isPapiError(err, http.StatusNotFound, "AEC_NOT_FOUND")
+}
+
+// IsConflict returns true if err is a PapiError for a resource that already exists or is in a conflicting state
+func IsConflict(err error) bool {
+	return isPapiError(err, http.StatusConflict, "AEC_CONFLICT")
+}
+
+// IsUnauthorized returns true if err is a PapiError caused by missing or invalid credentials
+func IsUnauthorized(err error) bool {
+	return isPapiError(err, http.StatusUnauthorized, "AEC_UNAUTHORIZED")
+}
+
+// IsForbidden returns true if err is a PapiError caused by the user not having the privileges required for the call
+func IsForbidden(err error) bool {
+	return isPapiError(err, http.StatusForbidden, "AEC_FORBIDDEN")
+}
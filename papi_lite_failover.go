@@ -0,0 +1,218 @@
+package papilite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultFailoverCooldown is the number of seconds an endpoint that failed is tried after all other endpoints
+const defaultFailoverCooldown int = 60
+
+// SetEndpoints is a setter used to set additional node endpoints of the same cluster. Endpoint is tried first and the
+// additional endpoints are used in order when the current endpoint cannot be reached. Each endpoint has the same
+// format as Endpoint, e.g. https://node2.cluster.fqdn:8080
+// If SetEndpoints is used after a connection has already been made the new endpoints are used on the next failover
+func (ctx *PapiSession) SetEndpoints(endpoints []string) []string {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	old := ctx.Endpoints
+	ctx.Endpoints = endpoints
+	return old
+}
+
+// SetFailoverCooldown is a setter used to set the time in seconds an endpoint that could not be reached is only tried
+// after all other endpoints
+func (ctx *PapiSession) SetFailoverCooldown(t int) int {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	old := ctx.FailoverCooldown
+	ctx.FailoverCooldown = t
+	return old
+}
+
+// ActiveEndpoint returns the endpoint the session is currently connected to. This differs from Endpoint after a
+// failover to one of the additional Endpoints
+func (ctx *PapiSession) ActiveEndpoint() string {
+	return ctx.state().endpoint
+}
+
+// CheckEndpoints checks whether every configured endpoint responds to HTTP requests. The result contains an entry for
+// every endpoint with a nil error for healthy endpoints. Endpoints that fail the check are only used for failover
+// after all healthy endpoints and endpoints that pass the check are used again immediately. The check can be called
+// periodically, e.g. from a time.Ticker, to keep the endpoint state current between requests
+func (ctx *PapiSession) CheckEndpoints() map[string]error {
+	return ctx.CheckEndpointsContext(context.Background())
+}
+
+// CheckEndpointsContext is the same as CheckEndpoints but the health check requests are bound to the passed in
+// context.Context
+func (ctx *PapiSession) CheckEndpointsContext(reqCtx context.Context) map[string]error {
+	ctx.mu.RLock()
+	endpoints := ctx.endpointList()
+	client := ctx.Client
+	ctx.mu.RUnlock()
+	results := make(map[string]error, len(endpoints))
+	if client == nil {
+		var err error
+		client, err = ctx.newClient()
+		if err != nil {
+			for _, endpoint := range endpoints {
+				results[endpoint] = err
+			}
+			return results
+		}
+		defer client.CloseIdleConnections()
+	}
+	var wg sync.WaitGroup
+	var resultMu sync.Mutex
+	for _, endpoint := range endpoints {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			err := checkEndpoint(reqCtx, client, endpoint)
+			resultMu.Lock()
+			results[endpoint] = err
+			resultMu.Unlock()
+		}(endpoint)
+	}
+	wg.Wait()
+	ctx.mu.Lock()
+	for endpoint, err := range results {
+		if err != nil {
+			ctx.markEndpointDown(endpoint)
+		} else {
+			delete(ctx.endpointDown, endpoint)
+		}
+	}
+	ctx.mu.Unlock()
+	return results
+}
+
+// checkEndpoint sends an unauthenticated request to the session service of an endpoint. Any response other than a
+// server error means the node is up
+func checkEndpoint(reqCtx context.Context, client *http.Client, endpoint string) error {
+	req, err := http.NewRequestWithContext(reqCtx, "GET", endpointURL(endpoint, sessionPath, nil), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	discardResponse(resp)
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("[CheckEndpoints] Endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// endpointList returns Endpoint followed by the additional Endpoints without empty and duplicate values. The caller
+// must hold the session lock
+func (ctx *PapiSession) endpointList() []string {
+	seen := map[string]bool{}
+	var endpoints []string
+	for _, endpoint := range append([]string{ctx.Endpoint}, ctx.Endpoints...) {
+		if endpoint == "" || seen[endpoint] {
+			continue
+		}
+		seen[endpoint] = true
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints
+}
+
+// endpointCandidates returns the endpoints in the order a connection should try them. The active endpoint is kept if
+// it is healthy and endpoints that recently failed are moved to the end. The caller must hold the session lock
+func (ctx *PapiSession) endpointCandidates() []string {
+	var healthy, down []string
+	for _, endpoint := range ctx.endpointList() {
+		switch {
+		case ctx.isEndpointDown(endpoint):
+			down = append(down, endpoint)
+		case endpoint == ctx.activeEndpoint:
+			healthy = append([]string{endpoint}, healthy...)
+		default:
+			healthy = append(healthy, endpoint)
+		}
+	}
+	return append(healthy, down...)
+}
+
+// isEndpointDown returns true if an endpoint failed within the failover cooldown. The caller must hold the session lock
+func (ctx *PapiSession) isEndpointDown(endpoint string) bool {
+	failed, ok := ctx.endpointDown[endpoint]
+	if !ok {
+		return false
+	}
+	cooldown := ctx.FailoverCooldown
+	if cooldown <= 0 {
+		cooldown = defaultFailoverCooldown
+	}
+	return time.Since(failed) < time.Duration(cooldown)*time.Second
+}
+
+// markEndpointDown records that an endpoint could not be reached. The caller must hold the session lock
+func (ctx *PapiSession) markEndpointDown(endpoint string) {
+	if ctx.endpointDown == nil {
+		ctx.endpointDown = map[string]time.Time{}
+	}
+	ctx.endpointDown[endpoint] = time.Now()
+}
+
+// failover moves the session to the next endpoint after a request to the active endpoint failed with a connection
+// error. staleGeneration is the session generation used by the failed request. When multiple requests fail at the same
+// time only the first one moves the session and all others simply retry on the new endpoint
+func (ctx *PapiSession) failover(reqCtx context.Context, staleGeneration uint64) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.Client != nil && ctx.generation != staleGeneration {
+		return nil
+	}
+	failed := ctx.activeEndpoint
+	ctx.markEndpointDown(failed)
+	// The session cannot be deleted on a node that is not reachable so the local state is simply dropped
+	ctx.reset()
+	ctx.logger().Warn("[Failover] Endpoint unreachable, switching to another endpoint", "endpoint", failed)
+	return ctx.connect(reqCtx)
+}
+
+// canFailover returns true if a request that failed with err can be sent to another endpoint. Requests that never
+// reached the endpoint can always be sent again while other requests are only sent again if the method is idempotent
+func canFailover(method string, endpoints int, err error) bool {
+	if endpoints < 2 || !isConnectionError(err) {
+		return false
+	}
+	return isDialError(err) || isIdempotent(method)
+}
+
+// isConnectionError returns true for errors where the endpoint could not be reached or the connection was lost
+func isConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var opErr *net.OpError
+	return isDialError(err) || errors.As(err, &opErr) || isTransientError(err)
+}
+
+// isDialError returns true if a connection to the endpoint could not be established, so the request was never sent
+func isDialError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// endpointCount returns the number of distinct configured endpoints
+func (ctx *PapiSession) endpointCount() int {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	return len(ctx.endpointList())
+}
@@ -0,0 +1,156 @@
+package papilite
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/murkyl/go-papi-lite/internal/redact"
+)
+
+const redactedValue string = redact.Value
+
+// Logger is the interface used for all log output of the library. The method set matches *slog.Logger from the
+// log/slog package so a slog logger can be passed in directly. The args parameter contains alternating key/value pairs.
+// Request and response tracing is logged at the Debug level with passwords, session cookies and CSRF tokens redacted.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NopLogger is a Logger that discards all output
+type NopLogger struct{}
+
+// Debug discards the message
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+
+// Info discards the message
+func (NopLogger) Info(msg string, args ...interface{}) {}
+
+// Warn discards the message
+func (NopLogger) Warn(msg string, args ...interface{}) {}
+
+// Error discards the message
+func (NopLogger) Error(msg string, args ...interface{}) {}
+
+// stdLogger is the Logger used when no Logger is configured. Warnings and errors are written with the standard log
+// package while debug and info messages are discarded
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, args ...interface{}) {}
+
+func (stdLogger) Info(msg string, args ...interface{}) {}
+
+func (stdLogger) Warn(msg string, args ...interface{}) {
+	log.Print("[WARN]" + msg + formatArgs(args))
+}
+
+func (stdLogger) Error(msg string, args ...interface{}) {
+	log.Print("[ERROR]" + msg + formatArgs(args))
+}
+
+// formatArgs converts alternating key/value pairs into a string of key=value items
+func formatArgs(args []interface{}) string {
+	var sb strings.Builder
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&sb, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&sb, " %v", args[i])
+		}
+	}
+	return sb.String()
+}
+
+// redactedHeader wraps HTTP headers for logging. Sensitive header values are only replaced when the value is
+// actually formatted so there is no cost when debug logging is disabled
+type redactedHeader http.Header
+
+// String returns the headers with sensitive values replaced
+func (h redactedHeader) String() string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := make([]string, 0, len(keys))
+	for _, k := range keys {
+		value := strings.Join(h[k], ", ")
+		if isSensitiveHeader(k) {
+			value = redactedValue
+		}
+		items = append(items, k+": "+value)
+	}
+	return "{" + strings.Join(items, "; ") + "}"
+}
+
+// MarshalText allows structured loggers to format the headers
+func (h redactedHeader) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// isSensitiveHeader returns true for headers that contain credentials or session tokens
+func isSensitiveHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Cookie", "Set-Cookie", "X-Csrf-Token":
+		return true
+	}
+	return false
+}
+
+// redactedBody wraps a request or response body for logging. JSON bodies have the values of any sensitive keys
+// replaced. The body is only processed when the value is actually formatted
+type redactedBody []byte
+
+// String returns the body with sensitive values replaced
+func (b redactedBody) String() string {
+	if len(b) == 0 {
+		return ""
+	}
+	var obj interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return fmt.Sprintf("<%d bytes of non JSON data>", len(b))
+	}
+	raw, _ := json.Marshal(redact.JSON(obj))
+	return string(raw)
+}
+
+// MarshalText allows structured loggers to format the body
+func (b redactedBody) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// debugJSON returns a value for logging that formats obj as JSON with sensitive values redacted. The encoding is only
+// done when the value is actually formatted
+func debugJSON(obj interface{}) fmt.Stringer {
+	return debugJSONValue{obj}
+}
+
+// debugJSONValue is the value returned by debugJSON
+type debugJSONValue struct {
+	obj interface{}
+}
+
+// String returns the JSON encoding of the object with sensitive values replaced
+func (v debugJSONValue) String() string {
+	var obj interface{}
+	raw, err := json.Marshal(v.obj)
+	if err == nil {
+		err = json.Unmarshal(raw, &obj)
+	}
+	if err != nil {
+		return fmt.Sprintf("<unable to encode JSON: %v>", err)
+	}
+	raw, _ = json.Marshal(redact.JSON(obj))
+	return string(raw)
+}
+
+// MarshalText allows structured loggers to format the object
+func (v debugJSONValue) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
@@ -0,0 +1,169 @@
+package papilite
+
+import (
+	"context"
+	"fmt"
+)
+
+// Pager iterates over the individual pages of an API call that returns resume tokens. Each call to Next fetches
+// a single page from the endpoint so only one page is held in memory at a time. The resume token returned in a page
+// is followed lazily on the next call to Next.
+//
+//	pager := conn.NewPager("GET", "platform/latest/quota/quotas", nil, nil, nil)
+//	for pager.Next() {
+//		for _, quota := range pager.Items("quotas") {
+//			fmt.Printf("Quota: %v\n", quota)
+//		}
+//	}
+//	if err := pager.Err(); err != nil {
+//		fmt.Printf("Error: %s\n", err)
+//	}
+type Pager struct {
+	session   *PapiSession
+	reqCtx    context.Context
+	method    string
+	path      interface{}
+	query     map[string]string
+	body      interface{}
+	headers   map[string]string
+	resumeKey string
+	page      map[string]interface{}
+	count     int
+	done      bool
+	err       error
+}
+
+// NewPager returns a Pager for the given request. No request is sent until the first call to Next
+func (ctx *PapiSession) NewPager(method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) *Pager {
+	return ctx.NewPagerContext(context.Background(), method, path, query, body, headers)
+}
+
+// NewPagerContext is the same as NewPager but all page requests are bound to the passed in context.Context
+func (ctx *PapiSession) NewPagerContext(reqCtx context.Context, method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) *Pager {
+	return &Pager{
+		session: ctx,
+		reqCtx:  reqCtx,
+		method:  method,
+		path:    path,
+		query:   query,
+		body:    body,
+		headers: headers,
+	}
+}
+
+// Next fetches the next page from the endpoint. It returns false when there are no more pages or when an error
+// occurred. Err should be checked after Next returns false
+func (p *Pager) Next() bool {
+	p.page = nil
+	if p.done {
+		return false
+	}
+	// The count variable puts an upper limit on the number of times the pager will automatically fetch additional data
+	if p.count >= maxCount {
+		p.done = true
+		return false
+	}
+	// Prepare the body once so every page request sends the same body
+	if p.count == 0 {
+		body, err := newRequestBody(p.body)
+		if err != nil {
+			p.err = fmt.Errorf("[Pager] Unable to marshal request body: %w", err)
+			p.done = true
+			return false
+		}
+		p.body = body
+	}
+	query := p.query
+	if p.resumeKey != "" {
+		// When a resume key is used all old query parameters should be discarded and only the resume key in the query arguments list
+		query = map[string]string{"resume": p.resumeKey}
+	}
+	page, err := p.session.sendPage(p.reqCtx, p.method, p.path, query, p.body, p.headers)
+	p.count++
+	if err != nil {
+		p.err = err
+		p.done = true
+		return false
+	}
+	if page == nil {
+		p.done = true
+		return false
+	}
+	p.resumeKey = ""
+	if rkey, ok := page["resume"]; ok && rkey != nil {
+		resumeKey, ok := rkey.(string)
+		if !ok {
+			p.err = fmt.Errorf("[Pager] Invalid resume key in response: %v", rkey)
+			p.done = true
+			return false
+		}
+		p.resumeKey = resumeKey
+	}
+	if p.resumeKey == "" {
+		p.done = true
+	}
+	// Remove extraneous fields from the JSON response as they are only used with continued responses
+	delete(page, "errors")
+	delete(page, "resume")
+	p.page = page
+	return true
+}
+
+// Page returns the JSON object of the page fetched by the last call to Next. The resume key is removed from the page
+func (p *Pager) Page() map[string]interface{} {
+	return p.page
+}
+
+// Items is a helper that returns the list stored under key in the current page. An empty list is returned if the key
+// does not exist or is not a list
+func (p *Pager) Items(key string) []interface{} {
+	items, _ := p.page[key].([]interface{})
+	return items
+}
+
+// Err returns the first error encountered by the Pager
+func (p *Pager) Err() error {
+	return p.err
+}
+
+// ItemIterator walks over the individual items of a list in a paginated response. Pages are fetched from the
+// underlying Pager only when all the items of the current page have been consumed.
+type ItemIterator struct {
+	pager *Pager
+	key   string
+	items []interface{}
+	item  interface{}
+}
+
+// NewItemIterator returns an ItemIterator that returns the entries of the list stored under key in each page
+func NewItemIterator(pager *Pager, key string) *ItemIterator {
+	return &ItemIterator{
+		pager: pager,
+		key:   key,
+	}
+}
+
+// Next advances to the next item, fetching another page if required. It returns false when there are no more items
+// or when an error occurred. Err should be checked after Next returns false
+func (it *ItemIterator) Next() bool {
+	for len(it.items) == 0 {
+		if !it.pager.Next() {
+			it.item = nil
+			return false
+		}
+		it.items = it.pager.Items(it.key)
+	}
+	it.item = it.items[0]
+	it.items = it.items[1:]
+	return true
+}
+
+// Item returns the item selected by the last call to Next
+func (it *ItemIterator) Item() interface{} {
+	return it.item
+}
+
+// Err returns the first error encountered while fetching pages
+func (it *ItemIterator) Err() error {
+	return it.pager.Err()
+}
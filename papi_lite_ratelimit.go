@@ -0,0 +1,183 @@
+package papilite
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that limits how many requests are sent per second. The bucket holds up to burst tokens
+// and is refilled at rate tokens per second. Every request takes one token and waits until a token is available.
+// A RateLimiter is safe for concurrent use and can be shared by multiple PapiSession or OnefsConn values to limit the
+// total request rate against a cluster.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// pausedUntil is set when the cluster asks clients to back off with a Retry-After header
+	pausedUntil time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows rate requests per second with bursts of up to burst requests. A
+// burst below 1 is set to 1 and a rate of 0 or less does not limit the rate
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a request may be sent or the context is done. A nil RateLimiter never blocks
+func (l *RateLimiter) Wait(reqCtx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	delay := l.reserve(time.Now())
+	if err := sleepContext(reqCtx, delay); err != nil {
+		// Return the reserved token so a cancelled request does not slow down other requests
+		l.mu.Lock()
+		if l.rate > 0 {
+			l.tokens++
+		}
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// reserve takes a token and returns how long the caller has to wait before using it
+func (l *RateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var delay time.Duration
+	if l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		l.tokens--
+		if l.tokens < 0 {
+			delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+		}
+	}
+	if pause := l.pausedUntil.Sub(now); pause > delay {
+		delay = pause
+	}
+	return delay
+}
+
+// pause stops all requests through the limiter until the given time
+func (l *RateLimiter) pause(until time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// InFlightLimiter limits how many requests are in flight at the same time. A request is in flight from the moment it
+// is sent until its response body is closed. An InFlightLimiter is safe for concurrent use and can be shared by
+// multiple PapiSession or OnefsConn values.
+type InFlightLimiter struct {
+	sem chan struct{}
+}
+
+// NewInFlightLimiter returns an InFlightLimiter that allows up to max concurrent requests. A max below 1 is set to 1
+func NewInFlightLimiter(max int) *InFlightLimiter {
+	if max < 1 {
+		max = 1
+	}
+	return &InFlightLimiter{sem: make(chan struct{}, max)}
+}
+
+// Acquire blocks until a request may be sent or the context is done. A nil InFlightLimiter never blocks
+func (l *InFlightLimiter) Acquire(reqCtx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return nil
+	case <-reqCtx.Done():
+		return reqCtx.Err()
+	}
+}
+
+// Release marks a request acquired with Acquire as done
+func (l *InFlightLimiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.sem
+}
+
+// releaseOnClose wraps the body of a response so the in flight slot is released when the body is closed
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// SetRateLimiter is a setter used to limit the request rate of the session. A nil RateLimiter disables rate limiting
+func (ctx *PapiSession) SetRateLimiter(l *RateLimiter) *RateLimiter {
+	old := ctx.RateLimiter
+	ctx.RateLimiter = l
+	return old
+}
+
+// SetInFlightLimiter is a setter used to limit the number of concurrent requests of the session. A nil InFlightLimiter
+// disables the limit
+func (ctx *PapiSession) SetInFlightLimiter(l *InFlightLimiter) *InFlightLimiter {
+	old := ctx.InFlightLimiter
+	ctx.InFlightLimiter = l
+	return old
+}
+
+// throttled returns true for status codes the cluster uses to ask clients to slow down
+func throttled(resp *http.Response) bool {
+	return resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable)
+}
+
+// retryAfter returns the delay requested by the Retry-After header of a response. The header can contain a number of
+// seconds or an HTTP date
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
@@ -0,0 +1,164 @@
+package papilite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts    int           = 4
+	defaultRetryInitialBackoff time.Duration = 500 * time.Millisecond
+	defaultRetryMaxBackoff     time.Duration = 30 * time.Second
+	defaultRetryMultiplier     float64       = 2
+	defaultRetryJitter         float64       = 0.2
+)
+
+// RetryPolicy controls how requests that fail with a transient error are retried. A request is retried when the
+// connection fails, e.g. a connection reset while a node reboots, or when the response status code is listed in
+// RetryableStatusCodes. Requests with a non idempotent method like POST are only retried when RetryNonIdempotent
+// is set as the cluster may have already acted on the original request.
+// The delay before retry n is InitialBackoff * Multiplier^(n-1), capped at MaxBackoff, with up to Jitter * delay
+// randomly added or removed.
+// When a 429 or 503 response has a Retry-After header and the requested delay is longer, the requested delay is used.
+// The requested delay is capped at MaxBackoff, or at 30 seconds if MaxBackoff is not set or no policy is set.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts for a request including the first one. Values below 2 disable retries
+	MaxAttempts          int
+	InitialBackoff       time.Duration
+	MaxBackoff           time.Duration
+	Multiplier           float64
+	Jitter               float64
+	RetryableStatusCodes []int
+	RetryNonIdempotent   bool
+	// ShouldRetry optionally replaces the default retry decision. It receives the request method and either the
+	// response or the error returned by the HTTP client. MaxAttempts still limits the number of attempts
+	ShouldRetry func(method string, resp *http.Response, err error) bool
+}
+
+// NewRetryPolicy returns a RetryPolicy with default values suitable for riding out node reboots and rolling upgrades.
+// The returned policy can be modified before being set on a PapiSession with SetRetryPolicy
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    defaultRetryMaxAttempts,
+		InitialBackoff: defaultRetryInitialBackoff,
+		MaxBackoff:     defaultRetryMaxBackoff,
+		Multiplier:     defaultRetryMultiplier,
+		Jitter:         defaultRetryJitter,
+		RetryableStatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// retry returns true if a request that has been tried attempt times should be tried again
+func (p *RetryPolicy) retry(method string, attempt int, resp *http.Response, err error) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if p.ShouldRetry != nil {
+		return p.ShouldRetry(method, resp, err)
+	}
+	if !p.RetryNonIdempotent && !isIdempotent(method) {
+		return false
+	}
+	if err != nil {
+		return isTransientError(err)
+	}
+	for _, code := range p.RetryableStatusCodes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the time to wait before the next attempt after attempt tries
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+// maxBackoff returns the longest delay the policy allows before a retry. It is safe to call on a nil policy
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p == nil || p.MaxBackoff <= 0 {
+		return defaultRetryMaxBackoff
+	}
+	return p.MaxBackoff
+}
+
+// isIdempotent returns true for HTTP methods that can safely be sent more than once
+func isIdempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// isTransientError returns true for connection level errors that are likely to succeed on a later attempt
+func isTransientError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return false
+}
+
+// discardResponse reads and closes the body of a response that will not be returned to the caller so the
+// underlying connection can be reused
+func discardResponse(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// sleepContext waits for the duration d or until the context is done, whichever comes first
+func sleepContext(reqCtx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return reqCtx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-reqCtx.Done():
+		return reqCtx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
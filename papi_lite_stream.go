@@ -0,0 +1,65 @@
+package papilite
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// StreamResponse is the response of a SendStream call. The caller must close Body when done reading it.
+type StreamResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       io.ReadCloser
+}
+
+// Close closes the body of the response
+func (r *StreamResponse) Close() error {
+	return r.Body.Close()
+}
+
+// SendStream performs an API call and returns the status, headers and body of the response without reading the body.
+// This is useful for responses that are not JSON or are too large to hold in memory, like file downloads from the
+// namespace API or text/plain output. Requests are re-authenticated and retried on a 401 like in Send and responses
+// with a status code outside of the 2xx range are returned as a PapiError. Unless the headers contain an Accept header,
+// any content type is accepted.
+//
+//	resp, err := conn.SendStream("GET", "namespace/ifs/data/file.txt", nil, nil, nil)
+//	if err != nil {
+//		return err
+//	}
+//	defer resp.Close()
+//	_, err = io.Copy(os.Stdout, resp.Body)
+func (ctx *PapiSession) SendStream(method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) (*StreamResponse, error) {
+	return ctx.SendStreamContext(context.Background(), method, path, query, body, headers)
+}
+
+// SendStreamContext is the same as SendStream but the request is bound to the passed in context.Context. Cancelling
+// the context also aborts reading the body
+func (ctx *PapiSession) SendStreamContext(reqCtx context.Context, method string, path interface{}, query map[string]string, body interface{}, headers map[string]string) (*StreamResponse, error) {
+	resp, err := ctx.sendAuthenticated(reqCtx, method, path, query, body, streamHeaders(headers))
+	if err != nil {
+		return nil, err
+	}
+	return &StreamResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       resp.Body,
+	}, nil
+}
+
+// streamHeaders returns a copy of headers with an Accept header that allows any content type if none was given
+func streamHeaders(headers map[string]string) map[string]string {
+	for key := range headers {
+		if strings.EqualFold(key, "Accept") {
+			return headers
+		}
+	}
+	result := make(map[string]string, len(headers)+1)
+	for key, value := range headers {
+		result[key] = value
+	}
+	result["Accept"] = "*/*"
+	return result
+}
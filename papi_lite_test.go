@@ -1,11 +1,27 @@
 package papilite
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/murkyl/go-papi-lite/papitest"
 )
 
 var (
@@ -80,3 +96,732 @@ func GetPlatformLatest(conn *PapiSession) (string, error) {
 	}
 	return jsonObj["latest"].(string), nil
 }
+
+// newTestSession starts a local HTTP server that handles session creation and passes all other requests to handler
+// The returned session is already connected to the server
+func newTestSession(t *testing.T, handler http.HandlerFunc) (*PapiSession, *httptest.Server) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+sessionPath {
+			http.SetCookie(w, &http.Cookie{Name: "isisessid", Value: "testsession"})
+			http.SetCookie(w, &http.Cookie{Name: "isicsrf", Value: "testcsrf"})
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		handler(w, r)
+	}))
+	conn := NewSession(srv.URL + "/")
+	if err := conn.Connect(); err != nil {
+		srv.Close()
+		t.Fatalf("Unable to connect to test server: %s", err)
+	}
+	return conn, srv
+}
+
+func TestSendContextCancel(t *testing.T) {
+	var calls int32
+	conn, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprintf(w, `{"items": [%d], "resume": "next"}`, atomic.LoadInt32(&calls))
+	})
+	defer srv.Close()
+	reqCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := conn.SendContext(reqCtx, "GET", "platform/1/items", nil, nil, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled error, got: %v", err)
+	}
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Errorf("Expected no requests to be sent after cancel, got %d", calls)
+	}
+}
+
+// pagedHandler returns a handler that serves the values 0 to total-1 under the "items" key, pageSize items per page
+func pagedHandler(total int, pageSize int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := 0
+		if resume := r.URL.Query().Get("resume"); resume != "" {
+			fmt.Sscanf(resume, "%d", &start)
+		}
+		end := start + pageSize
+		if end > total {
+			end = total
+		}
+		items := []int{}
+		for i := start; i < end; i++ {
+			items = append(items, i)
+		}
+		page := map[string]interface{}{"items": items, "total": total, "resume": nil}
+		if end < total {
+			page["resume"] = fmt.Sprintf("%d", end)
+		}
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+func TestSendMergesPages(t *testing.T) {
+	conn, srv := newTestSession(t, pagedHandler(25, 10))
+	defer srv.Close()
+	jsonObj, err := conn.Send("GET", "platform/1/items", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Send failed: %s", err)
+	}
+	items := jsonObj["items"].([]interface{})
+	if len(items) != 25 {
+		t.Errorf("Expected 25 merged items, got %d", len(items))
+	}
+	if _, ok := jsonObj["resume"]; ok {
+		t.Errorf("Resume key should not be present in the merged result")
+	}
+}
+
+func TestSendInto(t *testing.T) {
+	conn, srv := newTestSession(t, pagedHandler(25, 10))
+	defer srv.Close()
+	var result struct {
+		Items  []int  `json:"items"`
+		Total  int    `json:"total"`
+		Resume string `json:"resume"`
+	}
+	if err := conn.SendInto("GET", "platform/1/items", nil, nil, nil, &result); err != nil {
+		t.Fatalf("SendInto failed: %s", err)
+	}
+	if len(result.Items) != 25 || result.Items[24] != 24 || result.Total != 25 {
+		t.Errorf("Expected 25 merged items, got %d items and total %d", len(result.Items), result.Total)
+	}
+	var generic map[string]interface{}
+	if err := conn.SendInto("GET", "platform/1/items", nil, nil, nil, &generic); err != nil {
+		t.Fatalf("SendInto failed: %s", err)
+	}
+	if items, _ := generic["items"].([]interface{}); len(items) != 25 {
+		t.Errorf("Expected 25 merged items in a map, got %d", len(items))
+	}
+	if err := conn.SendInto("GET", "platform/1/items", nil, nil, nil, result); err == nil {
+		t.Errorf("Expected an error for a non pointer result")
+	}
+}
+
+func TestPager(t *testing.T) {
+	conn, srv := newTestSession(t, pagedHandler(25, 10))
+	defer srv.Close()
+	pager := conn.NewPager("GET", "platform/1/items", nil, nil, nil)
+	pages := 0
+	for pager.Next() {
+		pages++
+	}
+	if err := pager.Err(); err != nil {
+		t.Fatalf("Pager failed: %s", err)
+	}
+	if pages != 3 {
+		t.Errorf("Expected 3 pages, got %d", pages)
+	}
+	items := NewItemIterator(conn.NewPager("GET", "platform/1/items", nil, nil, nil), "items")
+	for i := 0; items.Next(); i++ {
+		if int(items.Item().(float64)) != i {
+			t.Errorf("Expected item %d, got %v", i, items.Item())
+		}
+	}
+	if err := items.Err(); err != nil {
+		t.Fatalf("ItemIterator failed: %s", err)
+	}
+}
+
+func TestSendPapiError(t *testing.T) {
+	conn, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors": [{"code": "AEC_NOT_FOUND", "message": "Object not found"}]}`)
+	})
+	defer srv.Close()
+	_, err := conn.Send("GET", "platform/1/auth/users/missing", nil, nil, nil)
+	var apiErr *PapiError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected a PapiError, got: %v", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Method != "GET" || apiErr.Path != "/platform/1/auth/users/missing" {
+		t.Errorf("Unexpected PapiError values: %+v", apiErr)
+	}
+	if len(apiErr.Errors) != 1 || apiErr.Errors[0].Code != "AEC_NOT_FOUND" {
+		t.Errorf("Expected decoded API errors, got: %+v", apiErr.Errors)
+	}
+	if !IsNotFound(err) || IsConflict(err) || IsUnauthorized(err) {
+		t.Errorf("Error helpers returned unexpected values for: %v", err)
+	}
+}
+
+func TestSendRetryPolicy(t *testing.T) {
+	var calls int32
+	conn, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"latest": "12"}`)
+	})
+	defer srv.Close()
+	policy := NewRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	conn.SetRetryPolicy(policy)
+	jsonObj, err := conn.Send("GET", "platform/latest", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Send failed after retries: %s", err)
+	}
+	if jsonObj["latest"] != "12" || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("Expected success on the third attempt, got %v after %d calls", jsonObj, calls)
+	}
+	// Non idempotent requests must not be replayed
+	atomic.StoreInt32(&calls, 0)
+	_, err = conn.Send("POST", "platform/1/auth/users", nil, nil, nil)
+	if err == nil || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Expected a single failed POST attempt, got %d calls with error: %v", calls, err)
+	}
+}
+
+// TestConcurrentSessionRenewal expires the session while many goroutines share it and verifies that only a single
+// new session is created. Run with -race to also check the session state for data races
+func TestConcurrentSessionRenewal(t *testing.T) {
+	var sessions int32
+	var current atomic.Value
+	current.Store("session0")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+sessionPath {
+			if r.Method == "POST" {
+				token := fmt.Sprintf("session%d", atomic.AddInt32(&sessions, 1))
+				current.Store(token)
+				http.SetCookie(w, &http.Cookie{Name: "isisessid", Value: token})
+				http.SetCookie(w, &http.Cookie{Name: "isicsrf", Value: "csrf"})
+				w.WriteHeader(http.StatusCreated)
+			}
+			return
+		}
+		if r.Header.Get("Cookie") != "isisessid="+current.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"latest": "12"}`)
+	}))
+	defer srv.Close()
+	conn := NewSession(srv.URL + "/")
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Unable to connect to test server: %s", err)
+	}
+	// Expire the session on the server side
+	current.Store("expired")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := conn.Send("GET", "platform/latest", nil, nil, nil); err != nil {
+				t.Errorf("Send failed: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&sessions); n != 2 {
+		t.Errorf("Expected a single session renewal, got %d sessions", n)
+	}
+}
+
+// testLogger records all formatted log messages
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) record(level string, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, level+" "+msg+formatArgs(args))
+}
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.record("DEBUG", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.record("INFO", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.record("WARN", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.record("ERROR", msg, args) }
+
+func TestLoggerRedaction(t *testing.T) {
+	conn, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"keys": {"access_id": "1_user_accid", "secret_key": "topsecret"}}`)
+	})
+	defer srv.Close()
+	logger := &testLogger{}
+	conn.SetLogger(logger)
+	_, err := conn.Send("POST", "platform/10/protocols/s3/keys/user", nil, `{"password": "hunter2"}`, nil)
+	if err != nil {
+		t.Fatalf("Send failed: %s", err)
+	}
+	if len(logger.messages) == 0 {
+		t.Fatal("Expected debug messages to be logged")
+	}
+	for _, msg := range logger.messages {
+		for _, secret := range []string{"hunter2", "topsecret", "testsession", "testcsrf"} {
+			if strings.Contains(msg, secret) {
+				t.Errorf("Log message contains sensitive value %s: %s", secret, msg)
+			}
+		}
+	}
+}
+
+func TestLoggerRedactionKeepsNonStringValues(t *testing.T) {
+	conn, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"users": [{"name": "user1", "password": "hunter2", "password_expires": true, "password_last_set": 1700000000}]}`)
+	})
+	defer srv.Close()
+	logger := &testLogger{}
+	conn.SetLogger(logger)
+	if _, err := conn.Send("GET", "platform/10/auth/users/user1", nil, nil, nil); err != nil {
+		t.Fatalf("Send failed: %s", err)
+	}
+	all := strings.Join(logger.messages, "\n")
+	if strings.Contains(all, "hunter2") {
+		t.Errorf("Log messages contain the password: %s", all)
+	}
+	// Flags and timestamps of sensitive keys are not secret and are kept to help debugging
+	for _, kept := range []string{`"password_expires":true`, `"password_last_set":1700000000`} {
+		if !strings.Contains(all, kept) {
+			t.Errorf("Expected the log messages to contain %s, got: %s", kept, all)
+		}
+	}
+}
+
+func TestBasicAuthMode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+sessionPath {
+			t.Errorf("No session requests expected in basic authentication mode: %s %s", r.Method, r.URL.Path)
+		}
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "admin" || password != "secret" || r.Header.Get("X-CSRF-Token") != "" || r.Header.Get("Cookie") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"latest": "12"}`)
+	}))
+	defer srv.Close()
+	conn := NewSession(srv.URL + "/")
+	conn.SetUser("admin")
+	conn.SetPassword("secret")
+	conn.SetAuthMode(AuthModeBasic)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+	latest, err := GetPlatformLatest(conn)
+	if err != nil || latest != "12" {
+		t.Errorf("Expected latest version 12, got %s with error: %v", latest, err)
+	}
+	if err := conn.Disconnect(); err != nil {
+		t.Errorf("Disconnect failed: %s", err)
+	}
+}
+
+func TestCredentialProviderRotation(t *testing.T) {
+	var password atomic.Value
+	password.Store("first")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pass, _ := r.BasicAuth(); pass != password.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"latest": "12"}`)
+	}))
+	defer srv.Close()
+	var calls int32
+	conn := NewSession(srv.URL + "/")
+	conn.SetAuthMode(AuthModeBasic)
+	conn.SetCredentialProvider(CredentialFunc(func(ctx context.Context) (string, string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "admin", password.Load().(string), nil
+	}))
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+	if _, err := GetPlatformLatest(conn); err != nil {
+		t.Fatalf("Request with initial credentials failed: %s", err)
+	}
+	// Rotate the password. The next request must fetch the new credentials from the provider
+	password.Store("second")
+	if _, err := GetPlatformLatest(conn); err != nil {
+		t.Fatalf("Request after password rotation failed: %s", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Expected credentials to be fetched twice, got %d", n)
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "isisessid", Value: "testsession"})
+		http.SetCookie(w, &http.Cookie{Name: "isicsrf", Value: "testcsrf"})
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	fingerprint := CertificateFingerprint(srv.Certificate())
+	tests := []struct {
+		name       string
+		ignoreCert bool
+		opts       *TLSOptions
+		success    bool
+	}{
+		{"untrusted", false, nil, false},
+		{"custom CA", false, &TLSOptions{CACertPEM: caPEM, MinVersion: tls.VersionTLS12}, true},
+		{"custom CA with server name", false, &TLSOptions{CACertPEM: caPEM, ServerName: "example.com"}, true},
+		{"custom CA with wrong server name", false, &TLSOptions{CACertPEM: caPEM, ServerName: "wrong.host.test"}, false},
+		{"pinned", true, &TLSOptions{PinnedFingerprints: []string{strings.ToUpper(fingerprint)}}, true},
+		{"wrong pin", true, &TLSOptions{PinnedFingerprints: []string{strings.Repeat("00", 32)}}, false},
+	}
+	for _, test := range tests {
+		conn := NewSession(srv.URL + "/")
+		conn.SetIgnoreCert(test.ignoreCert)
+		conn.SetTLSOptions(test.opts)
+		err := conn.Connect()
+		if test.success && err != nil {
+			t.Errorf("%s: expected connect to succeed, got: %s", test.name, err)
+		}
+		if !test.success && err == nil {
+			t.Errorf("%s: expected connect to fail", test.name)
+		}
+	}
+}
+
+// countingTransport counts the requests sent through the wrapped transport
+type countingTransport struct {
+	count int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.count, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestCustomTransport(t *testing.T) {
+	conn, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"latest": "12"}`)
+	})
+	defer srv.Close()
+	transport := &countingTransport{}
+	conn.SetTransport(transport)
+	// The transport must be used for all requests including after a reconnect
+	if err := conn.Reconnect(); err != nil {
+		t.Fatalf("Reconnect failed: %s", err)
+	}
+	if _, err := GetPlatformLatest(conn); err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	conn.Disconnect()
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+	if _, err := GetPlatformLatest(conn); err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	// Session create and request twice plus the session delete from Disconnect
+	if n := atomic.LoadInt32(&transport.count); n != 5 {
+		t.Errorf("Expected 5 requests through the custom transport, got %d", n)
+	}
+}
+
+// TestFakeSessionTimeout is the same as TestSessionTimeout but runs against the fake server and advances its clock
+// instead of sleeping
+func TestFakeSessionTimeout(t *testing.T) {
+	srv := papitest.NewServer()
+	defer srv.Close()
+	conn := NewSession(srv.URL)
+	conn.SetUser(papitest.DefaultUser)
+	conn.SetPassword(papitest.DefaultPassword)
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Unable to connect to API endpoint: %s", err)
+	}
+	if _, err := GetPlatformLatest(conn); err != nil {
+		t.Fatalf("Could not get the platform API version: %s", err)
+	}
+	// Advance the clock by 900 + 5 seconds to exceed the session inactive timer
+	srv.Advance(905 * time.Second)
+	if _, err := GetPlatformLatest(conn); err != nil {
+		t.Errorf("Did not re-authenticate properly: %s", err)
+	}
+	if n := srv.SessionCount(); n != 1 {
+		t.Errorf("Expected 1 active session after re-authentication, got %d", n)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	srv := papitest.NewServer()
+	defer srv.Close()
+	srv.SetCredentials("recorder", "recorder-secret-password")
+	srv.SetPageSize(2)
+	for i := 0; i < 5; i++ {
+		srv.AddUser("System", papitest.User{Name: fmt.Sprintf("user%d", i)})
+	}
+	send := func(transport http.RoundTripper) map[string]interface{} {
+		conn := NewSession(srv.URL)
+		conn.SetUser("recorder")
+		conn.SetPassword("recorder-secret-password")
+		conn.SetTransport(transport)
+		if err := conn.Connect(); err != nil {
+			t.Fatalf("Connect failed: %s", err)
+		}
+		defer conn.Disconnect()
+		jsonObj, err := conn.Send("GET", "platform/12/auth/users", nil, nil, nil)
+		if err != nil {
+			t.Fatalf("Send failed: %s", err)
+		}
+		return jsonObj
+	}
+	recorder := papitest.NewRecorder(nil)
+	recorded := send(recorder)
+	fixture := filepath.Join(t.TempDir(), "fixture.json")
+	if err := recorder.Save(fixture); err != nil {
+		t.Fatalf("Unable to save fixture: %s", err)
+	}
+	data, _ := ioutil.ReadFile(fixture)
+	if strings.Contains(string(data), "recorder-secret-password") {
+		t.Errorf("Fixture contains the password")
+	}
+	// Replay without the server
+	srv.Close()
+	replayer, err := papitest.LoadReplayer(fixture)
+	if err != nil {
+		t.Fatalf("Unable to load fixture: %s", err)
+	}
+	replayed := send(replayer)
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Errorf("Replayed result does not match the recorded result:\n%v\n%v", recorded, replayed)
+	}
+	if n := replayer.Remaining(); n != 0 {
+		t.Errorf("Expected all interactions to be replayed, %d remaining", n)
+	}
+}
+
+func TestEndpointFailover(t *testing.T) {
+	node1 := papitest.NewServer()
+	node2 := papitest.NewServer()
+	defer node2.Close()
+	// An endpoint with nothing listening to test failover during connect
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	conn := NewSession(down.URL)
+	conn.SetUser(papitest.DefaultUser)
+	conn.SetPassword(papitest.DefaultPassword)
+	conn.SetLogger(NopLogger{})
+	conn.SetEndpoints([]string{node1.URL, node2.URL})
+	if err := conn.Connect(); err != nil {
+		t.Fatalf("Unable to connect to any endpoint: %s", err)
+	}
+	defer conn.Disconnect()
+	if conn.ActiveEndpoint() != node1.URL {
+		t.Fatalf("Expected the session on %s, got %s", node1.URL, conn.ActiveEndpoint())
+	}
+
+	// Take down the active node. The next request must establish a new session on the remaining node
+	node1.Close()
+	if _, err := GetPlatformLatest(conn); err != nil {
+		t.Fatalf("Request was not failed over: %s", err)
+	}
+	if conn.ActiveEndpoint() != node2.URL {
+		t.Errorf("Expected the session on %s after failover, got %s", node2.URL, conn.ActiveEndpoint())
+	}
+	if n := node2.SessionCount(); n != 1 {
+		t.Errorf("Expected 1 session on the remaining node, got %d", n)
+	}
+
+	health := conn.CheckEndpoints()
+	if health[node2.URL] != nil || health[node1.URL] == nil || health[down.URL] == nil {
+		t.Errorf("Unexpected endpoint health: %v", health)
+	}
+}
+
+func TestRateLimiting(t *testing.T) {
+	var running, maxRunning, calls int32
+	conn, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/platform/throttled" && atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprint(w, `{"latest": "12"}`)
+	})
+	defer srv.Close()
+	conn.SetInFlightLimiter(NewInFlightLimiter(2))
+	conn.SetRateLimiter(NewRateLimiter(100, 2))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := conn.Send("GET", "platform/latest", nil, nil, nil); err != nil {
+				t.Errorf("Send failed: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxRunning > 2 {
+		t.Errorf("Expected at most 2 requests in flight, got %d", maxRunning)
+	}
+	// 2 requests are allowed immediately and the remaining 8 are spaced 10ms apart
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Errorf("Requests were not rate limited, 10 requests took %s", elapsed)
+	}
+
+	// A 429 response with a Retry-After header delays the retry by at least the requested time
+	policy := NewRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	conn.SetRetryPolicy(policy)
+	start = time.Now()
+	if _, err := conn.Send("GET", "platform/throttled", nil, nil, nil); err != nil {
+		t.Fatalf("Send failed after a throttled response: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Retry-After was not honored, retry after %s", elapsed)
+	}
+}
+
+func TestRetryAfterCapped(t *testing.T) {
+	var calls int32
+	conn, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "86400")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"latest": "12"}`)
+	})
+	defer srv.Close()
+	conn.SetRateLimiter(NewRateLimiter(100, 2))
+	policy := NewRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = 50 * time.Millisecond
+	conn.SetRetryPolicy(policy)
+	reqCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := conn.SendContext(reqCtx, "GET", "platform/latest", nil, nil, nil); err != nil {
+		t.Fatalf("Send failed after a throttled response: %s", err)
+	}
+	// A following request is not blocked by the paused rate limiter either
+	if _, err := conn.SendContext(reqCtx, "GET", "platform/latest", nil, nil, nil); err != nil {
+		t.Fatalf("Send failed after a throttled response: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the Retry-After delay to be capped at MaxBackoff, requests took %s", elapsed)
+	}
+}
+
+func TestSendRequestBodies(t *testing.T) {
+	var calls int32
+	conn, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		// The first request to the flaky path fails so the body has to be sent again
+		if r.URL.Path == "/platform/flaky" && atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"received": string(data)})
+	})
+	defer srv.Close()
+	policy := NewRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	conn.SetRetryPolicy(policy)
+
+	tests := []struct {
+		name string
+		path string
+		body interface{}
+		want string
+	}{
+		{"struct", "platform/echo", OnefsID{Name: "user1", Type: "user"}, `{"name":"user1","type":"user"}`},
+		{"map", "platform/echo", map[string]interface{}{"enabled": true}, `{"enabled":true}`},
+		{"string", "platform/echo", `{"a":1}`, `{"a":1}`},
+		{"stream", "platform/echo", io.MultiReader(strings.NewReader(`{"a":`), strings.NewReader(`2}`)), `{"a":2}`},
+		{"seekable retried", "platform/flaky", strings.NewReader(`{"a":3}`), `{"a":3}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			jsonObj, err := conn.Send("PUT", tc.path, nil, tc.body, nil)
+			if err != nil {
+				t.Fatalf("Send failed: %s", err)
+			}
+			if jsonObj["received"] != tc.want {
+				t.Errorf("Expected body %s, got %v", tc.want, jsonObj["received"])
+			}
+		})
+	}
+	// A stream that cannot be rewound is not retried
+	atomic.StoreInt32(&calls, 0)
+	_, err := conn.Send("PUT", "platform/flaky", nil, io.MultiReader(strings.NewReader(`{}`)), nil)
+	var papiErr *PapiError
+	if !errors.As(err, &papiErr) || papiErr.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Expected a single failed attempt for a stream, got %d calls with error: %v", calls, err)
+	}
+	if _, err := conn.Send("PUT", "platform/echo", nil, make(chan int), nil); err == nil {
+		t.Errorf("Expected an error for a body that cannot be marshaled")
+	}
+}
+
+func TestSendStream(t *testing.T) {
+	var unauthorized int32
+	conn, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/namespace/ifs/file.txt":
+			// The first request fails with a 401 to force a re-authentication
+			if atomic.AddInt32(&unauthorized, 1) == 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("X-Accept", r.Header.Get("Accept"))
+			io.WriteString(w, "file contents")
+		case "/platform/array":
+			io.WriteString(w, ` [{"name": "a"}, {"name": "b"}] `)
+		case "/platform/empty":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"errors": [{"code": "AEC_NOT_FOUND", "message": "Path not found"}]}`)
+		}
+	})
+	defer srv.Close()
+
+	resp, err := conn.SendStream("GET", "namespace/ifs/file.txt", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("SendStream failed: %s", err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Close()
+	if err != nil || string(data) != "file contents" {
+		t.Errorf("Expected file contents, got %q with error: %v", data, err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/plain" || resp.Header.Get("X-Accept") != "*/*" {
+		t.Errorf("Unexpected response status %d and headers %v", resp.StatusCode, resp.Header)
+	}
+	_, err = conn.SendStream("GET", "namespace/ifs/missing", nil, nil, nil)
+	var papiErr *PapiError
+	if !errors.As(err, &papiErr) || papiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 PapiError, got: %v", err)
+	}
+
+	jsonObj, err := conn.Send("GET", "platform/array", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Send failed for array response: %s", err)
+	}
+	if items, ok := jsonObj[ArrayResponseKey].([]interface{}); !ok || len(items) != 2 {
+		t.Errorf("Expected 2 items under %s, got %v", ArrayResponseKey, jsonObj)
+	}
+	var ids []OnefsID
+	if err := conn.SendInto("GET", "platform/array", nil, nil, nil, &ids); err != nil || len(ids) != 2 || ids[1].Name != "b" {
+		t.Errorf("Expected 2 decoded items, got %v with error: %v", ids, err)
+	}
+	jsonObj, err = conn.Send("DELETE", "platform/empty", nil, nil, nil)
+	if err != nil || jsonObj != nil {
+		t.Errorf("Expected a nil result for a 204 response, got %v with error: %v", jsonObj, err)
+	}
+}
@@ -0,0 +1,113 @@
+package papilite
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// TLSOptions contains the TLS settings used to connect to an HTTPS endpoint. All fields are optional.
+// Certificate pinning is performed in addition to normal certificate verification. To trust a self-signed cluster
+// certificate by its fingerprint alone, set IgnoreCert on the session together with PinnedFingerprints. The chain
+// verification is then skipped but the connection is still rejected unless a pinned certificate is presented.
+type TLSOptions struct {
+	// CACertFile is the path to a PEM file with CA certificates trusted in addition to the system roots
+	CACertFile string
+	// CACertPEM contains PEM encoded CA certificates trusted in addition to the system roots
+	CACertPEM []byte
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS12. The Go default is used when this is 0
+	MinVersion uint16
+	// ServerName overrides the name used for SNI and certificate verification. Use this when connecting by IP
+	ServerName string
+	// PinnedFingerprints are hex encoded SHA-256 fingerprints of certificates. At least one certificate presented by
+	// the endpoint must match one of the fingerprints. Colons in the fingerprints are ignored
+	PinnedFingerprints []string
+	// ClientCertFile and ClientKeyFile are the paths to a PEM encoded client certificate and key
+	ClientCertFile string
+	ClientKeyFile  string
+	// ClientCertPEM and ClientKeyPEM contain a PEM encoded client certificate and key
+	ClientCertPEM []byte
+	ClientKeyPEM  []byte
+}
+
+// buildTLSConfig creates the tls.Config for a session from the TLS options and the ignoreCert flag
+func buildTLSConfig(opts *TLSOptions, ignoreCert bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		InsecureSkipVerify: ignoreCert,
+	}
+	if opts == nil {
+		return cfg, nil
+	}
+	cfg.MinVersion = opts.MinVersion
+	cfg.ServerName = opts.ServerName
+	if opts.CACertFile != "" || len(opts.CACertPEM) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if opts.CACertFile != "" {
+			pem, err := ioutil.ReadFile(opts.CACertFile)
+			if err != nil {
+				return nil, fmt.Errorf("[TLS] Unable to read CA certificate file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("[TLS] No certificates found in CA certificate file: %s", opts.CACertFile)
+			}
+		}
+		if len(opts.CACertPEM) > 0 && !pool.AppendCertsFromPEM(opts.CACertPEM) {
+			return nil, errors.New("[TLS] No certificates found in CA certificate PEM data")
+		}
+		cfg.RootCAs = pool
+	}
+	switch {
+	case opts.ClientCertFile != "" || opts.ClientKeyFile != "":
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("[TLS] Unable to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	case len(opts.ClientCertPEM) > 0 || len(opts.ClientKeyPEM) > 0:
+		cert, err := tls.X509KeyPair(opts.ClientCertPEM, opts.ClientKeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("[TLS] Unable to parse client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if len(opts.PinnedFingerprints) > 0 {
+		pins := map[string]bool{}
+		for _, fp := range opts.PinnedFingerprints {
+			pin := normalizeFingerprint(fp)
+			if _, err := hex.DecodeString(pin); err != nil || len(pin) != sha256.Size*2 {
+				return nil, fmt.Errorf("[TLS] Invalid SHA-256 certificate fingerprint: %s", fp)
+			}
+			pins[pin] = true
+		}
+		// VerifyConnection is used instead of VerifyPeerCertificate as it is also called for resumed connections
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				if pins[CertificateFingerprint(cert)] {
+					return nil
+				}
+			}
+			return errors.New("[TLS] No certificate presented by the endpoint matches a pinned fingerprint")
+		}
+	}
+	return cfg, nil
+}
+
+// CertificateFingerprint returns the hex encoded SHA-256 fingerprint of a certificate in the format expected by
+// TLSOptions.PinnedFingerprints
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint removes separators and converts a fingerprint to lower case
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fp))
+}
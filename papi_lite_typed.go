@@ -0,0 +1,159 @@
+package papilite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// pageMeta contains the fields of a response that control pagination and report errors
+type pageMeta struct {
+	Resume string       `json:"resume"`
+	Errors []OnefsError `json:"errors"`
+}
+
+// SendInto performs an API call like Send but decodes the response directly into out using encoding/json. out must be
+// a non nil pointer, usually to a struct with json tags matching the response. The body is handled like in SendRaw.
+// Resume keys are followed automatically and the pages are merged into out: slices are appended and all other values
+// are replaced by the value of the later page. out is not modified when the response has no body.
+// A response that is a JSON array is decoded directly when out points to a slice or interface. For any other type the
+// array is decoded as if it was an object with the elements under ArrayResponseKey.
+//
+//	var result struct {
+//		Zones []OnefsAccessZone `json:"zones"`
+//	}
+//	err := conn.SendInto("GET", "platform/12/zones", nil, nil, nil, &result)
+func (ctx *PapiSession) SendInto(method string, path interface{}, query map[string]string, body interface{}, headers map[string]string, out interface{}) error {
+	return ctx.SendIntoContext(context.Background(), method, path, query, body, headers, out)
+}
+
+// SendIntoContext is the same as SendInto but all requests, including any automatic resume and re-authentication
+// requests, are bound to the passed in context.Context
+func (ctx *PapiSession) SendIntoContext(reqCtx context.Context, method string, path interface{}, query map[string]string, body interface{}, headers map[string]string, out interface{}) error {
+	target := reflect.ValueOf(out)
+	if out == nil || target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("[SendInto] out must be a non nil pointer")
+	}
+	// The body is prepared once so a marshaling error is reported before any request and a seekable reader is
+	// rewound correctly for every page
+	reqBody, err := newRequestBody(body)
+	if err != nil {
+		return fmt.Errorf("[SendInto] Unable to marshal request body: %w", err)
+	}
+	pageQuery := query
+	// The count variable puts an upper limit on the number of times resume keys are followed
+	for count := 0; count < maxCount; count++ {
+		rawBody, resp, err := ctx.sendPageRaw(reqCtx, method, path, pageQuery, reqBody, headers)
+		if err != nil {
+			return err
+		}
+		rawBody = bytes.TrimSpace(rawBody)
+		if resp.StatusCode == http.StatusNoContent || len(rawBody) == 0 {
+			return nil
+		}
+		if rawBody[0] == '[' {
+			// A JSON array is never paginated. It is decoded directly into a slice or interface and wrapped in an
+			// object under ArrayResponseKey for any other type
+			switch target.Elem().Kind() {
+			case reflect.Slice, reflect.Interface:
+			default:
+				rawBody = append(append([]byte(`{"`+ArrayResponseKey+`":`), rawBody...), '}')
+			}
+			if err := json.Unmarshal(rawBody, out); err != nil {
+				return fmt.Errorf("[SendInto] Error unmarshaling JSON into %T: %w", out, err)
+			}
+			return nil
+		}
+		var meta pageMeta
+		if err := json.Unmarshal(rawBody, &meta); err != nil {
+			return fmt.Errorf("[SendInto] Error unmarshaling JSON: %v", err)
+		}
+		if len(meta.Errors) > 0 {
+			return newPapiError(resp, rawBody)
+		}
+		if count == 0 {
+			err = json.Unmarshal(rawBody, out)
+		} else {
+			page := reflect.New(target.Elem().Type())
+			if err = json.Unmarshal(rawBody, page.Interface()); err == nil {
+				mergePage(target.Elem(), page.Elem())
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("[SendInto] Error unmarshaling JSON into %T: %w", out, err)
+		}
+		if meta.Resume == "" {
+			return nil
+		}
+		// When a resume key is used all old query parameters should be discarded and only the resume key in the query arguments list
+		pageQuery = map[string]string{"resume": meta.Resume}
+	}
+	return nil
+}
+
+// mergePage merges a decoded page into the result of the previous pages. Slices are appended, structs and maps are
+// merged field by field and all other values are replaced unless the page value is empty
+func mergePage(dst reflect.Value, src reflect.Value) {
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(src)
+			return
+		}
+		mergePage(dst.Elem(), src.Elem())
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		dstSlice, ok1 := dst.Interface().([]interface{})
+		srcSlice, ok2 := src.Interface().([]interface{})
+		if ok1 && ok2 {
+			dst.Set(reflect.ValueOf(append(dstSlice, srcSlice...)))
+			return
+		}
+		dst.Set(src)
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				mergePage(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Map:
+		if src.Len() == 0 {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		for _, key := range src.MapKeys() {
+			value := reflect.New(dst.Type().Elem()).Elem()
+			if existing := dst.MapIndex(key); existing.IsValid() {
+				value.Set(existing)
+			}
+			mergePage(value, src.MapIndex(key))
+			dst.SetMapIndex(key, value)
+		}
+	case reflect.Slice:
+		dst.Set(reflect.AppendSlice(dst, src))
+	default:
+		if !src.IsZero() {
+			dst.Set(src)
+		}
+	}
+}
+
+// decodeItem decodes a generic JSON value, like an item returned by an ItemIterator, into a typed value
+func decodeItem(item interface{}, out interface{}) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -1,12 +1,13 @@
 package papilite
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"net/http"
 )
 
 const (
 	defaultPapiWrapperLatestPath   string = "platform/latest"
+	defaultPapiWrapperConfigPath   string = "platform/1/cluster/config"
 	defaultPapiWrapperPlatformPath string = "platform/10"
 	defaultPapiWrapperRanPath      string = ""
 	defaultPapiWrapperServicePath  string = ""
@@ -18,6 +19,31 @@ type OnefsCfg struct {
 	Password   string
 	Endpoint   string
 	BypassCert bool
+	// Endpoints is optional and lists additional node endpoints of the same cluster that are used when Endpoint cannot
+	// be reached. See PapiSession.SetEndpoints
+	Endpoints []string
+	// TLS is optional and contains custom CA certificates, client certificates and certificate pinning settings
+	TLS *TLSOptions
+	// AuthMode selects session cookie (default) or HTTP basic authentication
+	AuthMode AuthMode
+	// Credentials is optional. When set it is used instead of User and Password to get the credentials
+	Credentials CredentialProvider
+	// Transport and ClientFactory are optional. When set they replace the HTTP transport or client of the underlying
+	// PapiSession. See PapiSession.SetTransport and PapiSession.SetClientFactory
+	Transport     http.RoundTripper
+	ClientFactory func() (*http.Client, error)
+	// MaxIdleConnsPerHost, IdleConnTimeout and KeepAlive configure the connection pool when set to a value above 0
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     int
+	KeepAlive           int
+	// RetryPolicy is optional. When set it replaces the retry policy of the underlying PapiSession
+	RetryPolicy *RetryPolicy
+	// Logger is optional. When set it replaces the Logger of the underlying PapiSession
+	Logger Logger
+	// RateLimiter and InFlightLimiter are optional and limit the request rate and the number of concurrent requests.
+	// The same limiters can be used in the configuration of multiple connections to share the limits between them
+	RateLimiter     *RateLimiter
+	InFlightLimiter *InFlightLimiter
 }
 
 // OnefsConn contains the state of a connection
@@ -26,61 +52,323 @@ type OnefsConn struct {
 	PlatformPath string
 	RanPath      string
 	ServicePath  string
+	// APIVersion is the latest platform API version and Release the OneFS release of the cluster. Both are discovered
+	// by Connect and are empty if the discovery failed
+	APIVersion int
+	Release    string
 }
 
 // OnefsError is the structure of API call errors
 type OnefsError struct {
-	Code    string `json:"code,omitempty" mapstructure:"code,omitempty"`
-	Message string `json:"message,omitempty" mapstructure:"message,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
 }
 
 // OnefsID represents a generic persona object in the API
 type OnefsID struct {
-	ID   string `json:"id,omitempty" mapstructure:"id,omitempty"`
-	Name string `json:"name,omitempty" mapstructure:"name,omitempty"`
-	Type string `json:"type,omitempty" mapstructure:"type,omitempty"`
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
 }
 
 // OnefsS3Key represents the data values returned in an S3 key create call
 type OnefsS3Key struct {
-	AccessID           string `json:"access_id,omitempty" mapstructure:"access_id"`
-	OldKeyExpiry       int    `json:"old_key_expiry,omitempty" mapstructure:"old_key_expiry"`
-	OldKeyTimestamp    int    `json:"old_key_timestamp,omitempty" mapstructure:"old_key_timestamp"`
-	SecretKey          string `json:"secret_key,omitempty" mapstructure:"secret_key"`
-	SecretKeyTimestamp int    `json:"secret_key_timestamp,omitempty" mapstructure:"secret_key_timestamp"`
+	AccessID           string `json:"access_id,omitempty"`
+	OldKeyExpiry       int    `json:"old_key_expiry,omitempty"`
+	OldKeyTimestamp    int    `json:"old_key_timestamp,omitempty"`
+	SecretKey          string `json:"secret_key,omitempty"`
+	SecretKeyTimestamp int    `json:"secret_key_timestamp,omitempty"`
 }
 
 // OnefsUser represents a local user
 type OnefsUser struct {
-	Name          string    `json:"name" mapstructure:"name"`
-	Email         string    `json:"email,omitempty" mapstructure:"email,omitempty"`
-	Enabled       bool      `json:"enabled,omitempty" mapstructure:"enabled,omitempty"`
-	Expiry        int       `json:"expiry,omitempty" mapstructure:"expiry,omitempty"`
-	HomeDirectory string    `json:"home_directory,omitempty" mapstructure:"home_directory,omitempty"`
-	MemberOf      []OnefsID `json:"member_of,omitempty" mapstructure:"member_of,omitempty"`
-	PrimaryGroup  OnefsID   `json:"primary_group,omitempty" mapstructure:"primary_group,omitempty"`
-	Shell         string    `json:"shell,omitempty" mapstructure:"shell,omitempty"`
+	Name               string    `json:"name"`
+	Email              string    `json:"email,omitempty"`
+	Enabled            bool      `json:"enabled,omitempty"`
+	Expiry             int       `json:"expiry,omitempty"`
+	Gecos              string    `json:"gecos,omitempty"`
+	HomeDirectory      string    `json:"home_directory,omitempty"`
+	LastLogon          int       `json:"last_logon,omitempty"`
+	Locked             bool      `json:"locked,omitempty"`
+	MemberOf           []OnefsID `json:"member_of,omitempty"`
+	OnDiskUserIdentity OnefsID   `json:"on_disk_user_identity,omitempty"`
+	PasswordExpired    bool      `json:"password_expired,omitempty"`
+	PasswordExpires    bool      `json:"password_expires,omitempty"`
+	PasswordLastSet    int       `json:"password_last_set,omitempty"`
+	PrimaryGroup       OnefsID   `json:"primary_group,omitempty"`
+	Provider           string    `json:"provider,omitempty"`
+	Shell              string    `json:"shell,omitempty"`
+	SID                OnefsID   `json:"sid,omitempty"`
+	UID                OnefsID   `json:"uid,omitempty"`
+}
+
+// OnefsUserOptions contains the values used to create a user with CreateUserWithOptions. Name is required and all
+// other fields are optional
+type OnefsUserOptions struct {
+	Name          string
+	Password      string
+	Email         string
+	Gecos         string
+	HomeDirectory string
+	Shell         string
+	// PrimaryGroup is the name of the primary group of the user. The default primary group is used if it is empty
+	PrimaryGroup string
+	// UID of the new user. A UID is assigned automatically if UID is set to 0
+	UID int
+	// Expiry is the time the account expires in seconds since the epoch. The account does not expire if Expiry is 0
+	Expiry int
+	// Enabled defaults to true if it is nil
+	Enabled *bool
+	// PasswordExpires defaults to the setting of the provider if it is nil
+	PasswordExpires *bool
+}
+
+// OnefsUserUpdate contains the values of a user that can be changed with ModifyUser. Only fields that are not nil are
+// changed
+type OnefsUserUpdate struct {
+	Email           *string  `json:"email,omitempty"`
+	Enabled         *bool    `json:"enabled,omitempty"`
+	Expiry          *int     `json:"expiry,omitempty"`
+	Gecos           *string  `json:"gecos,omitempty"`
+	HomeDirectory   *string  `json:"home_directory,omitempty"`
+	Password        *string  `json:"password,omitempty"`
+	PasswordExpires *bool    `json:"password_expires,omitempty"`
+	PrimaryGroup    *OnefsID `json:"primary_group,omitempty"`
+	Shell           *string  `json:"shell,omitempty"`
+	UID             *int     `json:"uid,omitempty"`
+	Unlock          *bool    `json:"unlock,omitempty"`
+}
+
+// OnefsGroup represents a group
+type OnefsGroup struct {
+	Dn             string    `json:"dn,omitempty"`
+	DNSDomain      string    `json:"dns_domain,omitempty"`
+	Domain         string    `json:"domain,omitempty"`
+	GeneratedGID   bool      `json:"generated_gid,omitempty"`
+	GID            OnefsID   `json:"gid,omitempty"`
+	ID             string    `json:"id,omitempty"`
+	MemberOf       []OnefsID `json:"member_of,omitempty"`
+	Name           string    `json:"name"`
+	Provider       string    `json:"provider,omitempty"`
+	SamAccountName string    `json:"sam_account_name,omitempty"`
+	SID            OnefsID   `json:"sid,omitempty"`
+	Type           string    `json:"type,omitempty"`
+}
+
+// OnefsGroupUpdate contains the values of a group that can be changed with ModifyGroup. Only fields that are not nil
+// are changed
+type OnefsGroupUpdate struct {
+	GID *int    `json:"gid,omitempty"`
+	SID *string `json:"sid,omitempty"`
+}
+
+// OnefsPrivilege represents an RBAC privilege. Clusters with a platform API version of 14 or later report the level of
+// access in Permission. Older clusters only report ReadOnly. Use Level to get the level for either
+type OnefsPrivilege struct {
+	Category    string `json:"category,omitempty"`
+	Description string `json:"description,omitempty"`
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	Permission  string `json:"permission,omitempty"`
+	ReadOnly    bool   `json:"read_only,omitempty"`
+}
+
+// OnefsRole represents an RBAC role
+type OnefsRole struct {
+	Description string           `json:"description,omitempty"`
+	ID          string           `json:"id,omitempty"`
+	Members     []OnefsID        `json:"members,omitempty"`
+	Name        string           `json:"name"`
+	Privileges  []OnefsPrivilege `json:"privileges,omitempty"`
+}
+
+// OnefsAdsProvider represents an Active Directory authentication provider
+type OnefsAdsProvider struct {
+	DNSDomain      string `json:"dns_domain,omitempty"`
+	Forest         string `json:"forest,omitempty"`
+	Groupnet       string `json:"groupnet,omitempty"`
+	Hostname       string `json:"hostname,omitempty"`
+	ID             string `json:"id,omitempty"`
+	MachineAccount string `json:"machine_account,omitempty"`
+	Name           string `json:"name"`
+	NetbiosDomain  string `json:"netbios_domain,omitempty"`
+	PrimaryDomain  string `json:"primary_domain,omitempty"`
+	Site           string `json:"site,omitempty"`
+	Status         string `json:"status,omitempty"`
+}
+
+// OnefsLdapProvider represents an LDAP authentication provider
+type OnefsLdapProvider struct {
+	BaseDN     string   `json:"base_dn,omitempty"`
+	BindDN     string   `json:"bind_dn,omitempty"`
+	Groupnet   string   `json:"groupnet,omitempty"`
+	ID         string   `json:"id,omitempty"`
+	Name       string   `json:"name"`
+	ServerURIs []string `json:"server_uris,omitempty"`
+	Status     string   `json:"status,omitempty"`
+	ZoneName   string   `json:"zone_name,omitempty"`
+}
+
+// OnefsNisProvider represents a NIS authentication provider
+type OnefsNisProvider struct {
+	Groupnet  string   `json:"groupnet,omitempty"`
+	ID        string   `json:"id,omitempty"`
+	Name      string   `json:"name"`
+	NisDomain string   `json:"nis_domain,omitempty"`
+	Servers   []string `json:"servers,omitempty"`
+	Status    string   `json:"status,omitempty"`
+	ZoneName  string   `json:"zone_name,omitempty"`
+}
+
+// OnefsFileProvider represents a file authentication provider
+type OnefsFileProvider struct {
+	GroupFile    string `json:"group_file,omitempty"`
+	ID           string `json:"id,omitempty"`
+	Name         string `json:"name"`
+	PasswordFile string `json:"password_file,omitempty"`
+	Status       string `json:"status,omitempty"`
+	ZoneName     string `json:"zone_name,omitempty"`
+}
+
+// OnefsLocalProvider represents the local authentication provider of an access zone
+type OnefsLocalProvider struct {
+	HomeDirectoryTemplate string `json:"home_directory_template,omitempty"`
+	ID                    string `json:"id,omitempty"`
+	LockoutThreshold      int    `json:"lockout_threshold,omitempty"`
+	MaxPasswordAge        int    `json:"max_password_age,omitempty"`
+	Name                  string `json:"name"`
+	Status                string `json:"status,omitempty"`
+	ZoneName              string `json:"zone_name,omitempty"`
+}
+
+// OnefsAuthProviderStatus represents the status of an authentication provider as returned by the provider summary
+type OnefsAuthProviderStatus struct {
+	ActiveServer string `json:"active_server,omitempty"`
+	Forest       string `json:"forest,omitempty"`
+	Groupnet     string `json:"groupnet,omitempty"`
+	ID           string `json:"id"`
+	Name         string `json:"name,omitempty"`
+	Site         string `json:"site,omitempty"`
+	Status       string `json:"status,omitempty"`
+	Type         string `json:"type,omitempty"`
+	ZoneName     string `json:"zone_name,omitempty"`
+}
+
+// OnefsAdsSPNCheck represents the result of checking the service principal names of an Active Directory provider
+type OnefsAdsSPNCheck struct {
+	Expected   []string `json:"expected,omitempty"`
+	Missing    []string `json:"missing,omitempty"`
+	Registered []string `json:"registered,omitempty"`
+}
+
+// OnefsMappingPersona represents a user or group in the access token returned by a user mapping lookup
+type OnefsMappingPersona struct {
+	GID                 OnefsID `json:"gid,omitempty"`
+	Name                string  `json:"name,omitempty"`
+	OnDiskGroupIdentity OnefsID `json:"on_disk_group_identity,omitempty"`
+	OnDiskUserIdentity  OnefsID `json:"on_disk_user_identity,omitempty"`
+	PrimaryGroupName    string  `json:"primary_group_name,omitempty"`
+	PrimaryGroupSID     OnefsID `json:"primary_group_sid,omitempty"`
+	SID                 OnefsID `json:"sid,omitempty"`
+	UID                 OnefsID `json:"uid,omitempty"`
+	UPN                 string  `json:"upn,omitempty"`
+}
+
+// OnefsAccessToken represents the access token of a user as returned by a user mapping lookup. The token contains the
+// user, all the groups of the user and the privileges granted through RBAC roles
+type OnefsAccessToken struct {
+	Groups     []OnefsMappingPersona `json:"groups,omitempty"`
+	Privileges []OnefsPrivilege      `json:"privileges,omitempty"`
+	User       OnefsMappingPersona   `json:"user,omitempty"`
+	ZID        int                   `json:"zid,omitempty"`
+	Zone       string                `json:"zone,omitempty"`
+}
+
+// OnefsIdentityMapping represents the identity mappings of a source persona
+type OnefsIdentityMapping struct {
+	Source  OnefsID               `json:"source"`
+	Targets []OnefsIdentityTarget `json:"targets,omitempty"`
+}
+
+// OnefsIdentityTarget represents a single target of an identity mapping. Type is one of equivalent, forward or reverse
+type OnefsIdentityTarget struct {
+	OnDisk bool    `json:"on_disk,omitempty"`
+	Target OnefsID `json:"target"`
+	Type   string  `json:"type,omitempty"`
+}
+
+// OnefsUserMappingRule represents a single user mapping rule of an access zone. Operator is one of append, insert,
+// join, replace or trim
+type OnefsUserMappingRule struct {
+	Operator string                       `json:"operator"`
+	Options  *OnefsUserMappingRuleOptions `json:"options,omitempty"`
+	User1    OnefsUserMappingRuleUser     `json:"user1"`
+	User2    *OnefsUserMappingRuleUser    `json:"user2,omitempty"`
+}
+
+// OnefsUserMappingRuleOptions represents the options of a user mapping rule
+type OnefsUserMappingRuleOptions struct {
+	Break       bool                      `json:"break,omitempty"`
+	DefaultUser *OnefsUserMappingRuleUser `json:"default_user,omitempty"`
+	Group       bool                      `json:"group,omitempty"`
+	Groups      bool                      `json:"groups,omitempty"`
+	User        bool                      `json:"user,omitempty"`
+}
+
+// OnefsUserMappingRuleUser represents a user in a user mapping rule. The user name may contain wildcards
+type OnefsUserMappingRuleUser struct {
+	Domain string `json:"domain,omitempty"`
+	User   string `json:"user"`
+}
+
+// OnefsPersona represents the result of resolving a persona. Type is either user or group. GID is only set for groups
+// and UID only for users
+type OnefsPersona struct {
+	GID  OnefsID `json:"gid,omitempty"`
+	Name string  `json:"name,omitempty"`
+	SID  OnefsID `json:"sid,omitempty"`
+	Type string  `json:"type,omitempty"`
+	UID  OnefsID `json:"uid,omitempty"`
+}
+
+// OnefsVersion represents the OneFS version information of a cluster
+type OnefsVersion struct {
+	Build    string `json:"build,omitempty"`
+	Release  string `json:"release,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Version  string `json:"version,omitempty"`
+}
+
+// OnefsClusterConfig represents the general configuration of a cluster
+type OnefsClusterConfig struct {
+	Description  string       `json:"description,omitempty"`
+	GUID         string       `json:"guid,omitempty"`
+	LocalDevID   int          `json:"local_devid,omitempty"`
+	LocalLnn     int          `json:"local_lnn,omitempty"`
+	Name         string       `json:"name,omitempty"`
+	OnefsVersion OnefsVersion `json:"onefs_version"`
 }
 
 // OnefsAccessZone represents an access zone
 type OnefsAccessZone struct {
-	AlternateSystemProvider  string    `json:"alternate_system_provider" mapstructure:"alternate_system_provider"`
-	AuthProviders            []string  `json:"auth_providers" mapstructure:"auth_providers"`
-	CacheEntryExpiry         int       `json:"cache_entry_expiry" mapstructure:"cache_entry_expirty"`
-	Groupnet                 string    `json:"groupnet" mapstructure:"groupnet"`
-	HomeDirectoryUmast       int       `json:"home_directory_umask" mapstructure:"home_directory_umask"`
-	ID                       string    `json:"id" mapstructure:"id"`
-	IfsRestricted            []OnefsID `json:"ifs_restricted" mapstructure:"ifs_restricted"`
-	MapUntrusted             string    `json:"map_untrusted" mapstructure:"map_untrusted"`
-	Name                     string    `json:"name" mapstructure:"name"`
-	NegativeCacheEntryExpiry int       `json:"negative_cache_entry_expiry" mapstructure:"negative_cache_entry_expiry"`
-	NetbiosName              string    `json:"netbios_name" mapstructure:"netbios_name"`
-	Path                     string    `json:"path" mapstructure:"path"`
-	SkeletonDirectory        string    `json:"skeleton_directory" mapstructure:"skeleton_directory"`
-	System                   bool      `json:"system" mapstructure:"system"`
-	SystemProvider           string    `json:"system_provider" mapstructure:"system_provider"`
-	UserMappingRules         []string  `json:"user_mapping_rules" mapstructure:"user_mapping_rule"`
-	ZoneID                   int       `json:"zone_id" mapstructure:"zone_id"`
+	AlternateSystemProvider  string    `json:"alternate_system_provider"`
+	AuthProviders            []string  `json:"auth_providers"`
+	CacheEntryExpiry         int       `json:"cache_entry_expiry"`
+	Groupnet                 string    `json:"groupnet"`
+	HomeDirectoryUmast       int       `json:"home_directory_umask"`
+	ID                       string    `json:"id"`
+	IfsRestricted            []OnefsID `json:"ifs_restricted"`
+	MapUntrusted             string    `json:"map_untrusted"`
+	Name                     string    `json:"name"`
+	NegativeCacheEntryExpiry int       `json:"negative_cache_entry_expiry"`
+	NetbiosName              string    `json:"netbios_name"`
+	Path                     string    `json:"path"`
+	SkeletonDirectory        string    `json:"skeleton_directory"`
+	System                   bool      `json:"system"`
+	SystemProvider           string    `json:"system_provider"`
+	UserMappingRules         []string  `json:"user_mapping_rules"`
+	ZoneID                   int       `json:"zone_id"`
 }
 
 // NewPapiConn returns a connection state object that is used by all other calls in this library
@@ -95,30 +383,71 @@ func NewPapiConn() *OnefsConn {
 
 // Connect performs the actual connection to the OneFS cluster endpoint given the endpoint configuration in a OnefsCfg struct
 func (conn *OnefsConn) Connect(cfg *OnefsCfg) error {
-	conn.Papi.Disconnect()
+	return conn.ConnectContext(context.Background(), cfg)
+}
+
+// ConnectContext is the same as Connect but the connection requests are bound to the passed in context.Context
+func (conn *OnefsConn) ConnectContext(ctx context.Context, cfg *OnefsCfg) error {
+	conn.Papi.DisconnectContext(ctx)
 	conn.Papi.SetEndpoint(cfg.Endpoint)
+	conn.Papi.SetEndpoints(cfg.Endpoints)
 	conn.Papi.SetUser(cfg.User)
 	conn.Papi.SetPassword(cfg.Password)
 	conn.Papi.SetIgnoreCert(cfg.BypassCert)
-	err := conn.Papi.Connect()
-	if err != nil {
-		log.Print(fmt.Sprintf("[Connect] Unable to connect to API endpoint: %s\n", err))
-		return err
+	conn.Papi.SetTLSOptions(cfg.TLS)
+	conn.Papi.SetAuthMode(cfg.AuthMode)
+	conn.Papi.SetCredentialProvider(cfg.Credentials)
+	if cfg.Transport != nil {
+		conn.Papi.SetTransport(cfg.Transport)
 	}
-	//log.Print(fmt.Sprintf("[Connect] Connected to PAPI with session ID: %s", conn.Papi.SessionToken))
-	apiVer, err := conn.GetPlatformLatest()
+	if cfg.ClientFactory != nil {
+		conn.Papi.SetClientFactory(cfg.ClientFactory)
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		conn.Papi.SetMaxIdleConnsPerHost(cfg.MaxIdleConnsPerHost)
+	}
+	if cfg.IdleConnTimeout > 0 {
+		conn.Papi.SetIdleConnTimeout(cfg.IdleConnTimeout)
+	}
+	if cfg.KeepAlive > 0 {
+		conn.Papi.SetKeepAlive(cfg.KeepAlive)
+	}
+	if cfg.RetryPolicy != nil {
+		conn.Papi.SetRetryPolicy(cfg.RetryPolicy)
+	}
+	if cfg.Logger != nil {
+		conn.Papi.SetLogger(cfg.Logger)
+	}
+	if cfg.RateLimiter != nil {
+		conn.Papi.SetRateLimiter(cfg.RateLimiter)
+	}
+	if cfg.InFlightLimiter != nil {
+		conn.Papi.SetInFlightLimiter(cfg.InFlightLimiter)
+	}
+	err := conn.Papi.ConnectContext(ctx)
 	if err != nil {
-		log.Print("Unable to get latest platform API version automatically")
-	} else {
-		conn.PlatformPath = "platform/" + apiVer
+		conn.Logger().Error("[Connect] Unable to connect to API endpoint", "endpoint", cfg.Endpoint, "error", err)
+		return err
 	}
+	conn.Logger().Debug("[Connect] Connected to PAPI", "endpoint", cfg.Endpoint)
+	conn.discoverVersion(ctx)
 	return nil
 }
 
+// Logger returns the Logger used by the connection
+func (conn *OnefsConn) Logger() Logger {
+	return conn.Papi.logger()
+}
+
 // Disconnect disconnects the connection to the endpoint. This is safe to call multiple times and even if a connect was never performed
 func (conn *OnefsConn) Disconnect() error {
+	return conn.DisconnectContext(context.Background())
+}
+
+// DisconnectContext is the same as Disconnect but the disconnect request is bound to the passed in context.Context
+func (conn *OnefsConn) DisconnectContext(ctx context.Context) error {
 	if conn.Papi != nil {
-		err := conn.Papi.Disconnect()
+		err := conn.Papi.DisconnectContext(ctx)
 		if err != nil {
 			return err
 		}
@@ -128,15 +457,25 @@ func (conn *OnefsConn) Disconnect() error {
 
 // GetPlatformLatest returns the current API version in string format of the connected OneFS cluster
 func (conn *OnefsConn) GetPlatformLatest() (string, error) {
-	jsonObj, err := conn.Papi.Send(
+	return conn.GetPlatformLatestContext(context.Background())
+}
+
+// GetPlatformLatestContext is the same as GetPlatformLatest but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetPlatformLatestContext(ctx context.Context) (string, error) {
+	var result struct {
+		Latest string `json:"latest"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
 		"GET",
 		defaultPapiWrapperLatestPath,
 		nil, // query args
 		nil, // body
 		nil, // extra headers
+		&result,
 	)
 	if err != nil {
 		return "", err
 	}
-	return jsonObj["latest"].(string), nil
+	return result.Latest, nil
 }
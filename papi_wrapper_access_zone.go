@@ -1,26 +1,31 @@
 package papilite
 
 import (
-	"github.com/mitchellh/mapstructure"
+	"context"
 )
 
 // GetAccessZoneList returns a list of all the access zones on a cluster
 func (conn *OnefsConn) GetAccessZoneList() ([]OnefsAccessZone, error) {
-	jsonObj, err := conn.Papi.Send(
+	return conn.GetAccessZoneListContext(context.Background())
+}
+
+// GetAccessZoneListContext is the same as GetAccessZoneList but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetAccessZoneListContext(ctx context.Context) ([]OnefsAccessZone, error) {
+	var result struct {
+		Zones []OnefsAccessZone `json:"zones"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
 		"GET",
 		conn.PlatformPath+"/zones",
 		nil, // query
 		nil, // body
 		nil, // extra headers
+		&result,
 	)
 	if err != nil {
 		return nil, err
 	}
-	//conn.Logger().Debug(fmt.Sprintf("[GetAccessZoneList] JSON: %s", debug_json(jsonObj)))
-	var result struct{ Zones []OnefsAccessZone }
-	err = mapstructure.Decode(jsonObj, &result)
-	if err != nil {
-		return nil, err
-	}
-	return result.Zones, err
+	conn.Logger().Debug("[GetAccessZoneList] Response", "json", debugJSON(result))
+	return result.Zones, nil
 }
@@ -1,92 +1,285 @@
 package papilite
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"github.com/mitchellh/mapstructure"
-	"log"
 )
 
 // CreateUser creates a new user in a given access zone
-// This function only provides some basic user configuration options like home directory and primary group
+// This function only provides some basic user configuration options like home directory and primary group. Use
+// CreateUserWithOptions to set any of the other options
 func (conn *OnefsConn) CreateUser(name string, homedir string, pgroup string, zone string) (map[string]interface{}, error) {
-	body := OnefsUser{
-		PrimaryGroup: OnefsID{
-			ID: "GROUP:" + pgroup,
-		},
-		Enabled:       true,
+	return conn.CreateUserContext(context.Background(), name, homedir, pgroup, zone)
+}
+
+// CreateUserContext is the same as CreateUser but the request is bound to the passed in context.Context
+func (conn *OnefsConn) CreateUserContext(ctx context.Context, name string, homedir string, pgroup string, zone string) (map[string]interface{}, error) {
+	return conn.CreateUserWithOptionsContext(ctx, OnefsUserOptions{
 		Name:          name,
 		HomeDirectory: homedir,
+		PrimaryGroup:  pgroup,
+	}, zone)
+}
+
+// CreateUserWithOptions creates a new user in a given access zone with the values in opts
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) CreateUserWithOptions(opts OnefsUserOptions, zone string) (map[string]interface{}, error) {
+	return conn.CreateUserWithOptionsContext(context.Background(), opts, zone)
+}
+
+// CreateUserWithOptionsContext is the same as CreateUserWithOptions but the request is bound to the passed in context.Context
+func (conn *OnefsConn) CreateUserWithOptionsContext(ctx context.Context, opts OnefsUserOptions, zone string) (map[string]interface{}, error) {
+	enabled := true
+	if opts.Enabled != nil {
+		enabled = *opts.Enabled
 	}
-	bodyJSON, err := json.Marshal(body)
-	if err != nil {
-		return nil, err
+	body := struct {
+		Name            string   `json:"name"`
+		Password        string   `json:"password,omitempty"`
+		Email           string   `json:"email,omitempty"`
+		Enabled         bool     `json:"enabled"`
+		Expiry          int      `json:"expiry,omitempty"`
+		Gecos           string   `json:"gecos,omitempty"`
+		HomeDirectory   string   `json:"home_directory,omitempty"`
+		PasswordExpires *bool    `json:"password_expires,omitempty"`
+		PrimaryGroup    *OnefsID `json:"primary_group,omitempty"`
+		Shell           string   `json:"shell,omitempty"`
+		UID             int      `json:"uid,omitempty"`
+	}{
+		Name:            opts.Name,
+		Password:        opts.Password,
+		Email:           opts.Email,
+		Enabled:         enabled,
+		Expiry:          opts.Expiry,
+		Gecos:           opts.Gecos,
+		HomeDirectory:   opts.HomeDirectory,
+		PasswordExpires: opts.PasswordExpires,
+		Shell:           opts.Shell,
+		UID:             opts.UID,
+	}
+	if opts.PrimaryGroup != "" {
+		body.PrimaryGroup = &OnefsID{ID: "GROUP:" + opts.PrimaryGroup}
 	}
 	if zone == "" {
 		zone = "System"
 	}
-	jsonBody, err := conn.Papi.Send(
+	conn.Logger().Debug("[CreateUser] Request", "body", debugJSON(body))
+	var result map[string]interface{}
+	err := conn.Papi.SendIntoContext(
+		ctx,
 		"POST",
 		conn.PlatformPath+"/auth/users",
 		map[string]string{"force": "True", "zone": zone},
-		bodyJSON, // body
-		nil,      // extra headers
+		body, // body
+		nil,  // extra headers
+		&result,
 	)
-	return jsonBody, err
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ModifyUser changes the settings of an existing user. Only the fields of update that are not nil are changed
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) ModifyUser(name string, update OnefsUserUpdate, zone string) error {
+	return conn.ModifyUserContext(context.Background(), name, update, zone)
+}
+
+// ModifyUserContext is the same as ModifyUser but the request is bound to the passed in context.Context
+func (conn *OnefsConn) ModifyUserContext(ctx context.Context, name string, update OnefsUserUpdate, zone string) error {
+	if zone == "" {
+		zone = "System"
+	}
+	conn.Logger().Debug("[ModifyUser] Request", "body", debugJSON(update))
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"PUT",
+		conn.PlatformPath+"/auth/users/"+name,
+		map[string]string{"force": "True", "zone": zone},
+		update, // body
+		nil,    // extra headers
+		&struct{}{},
+	)
+	if err != nil {
+		conn.Logger().Error("[ModifyUser] Error", "user", name, "zone", zone, "error", err)
+		return err
+	}
+	return nil
+}
+
+// ChangeUserPassword changes the password of a user. The current password of the user is required. An administrator
+// can set a new password without knowing the current one by setting Password with ModifyUser
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) ChangeUserPassword(name string, oldPassword string, newPassword string, zone string) error {
+	return conn.ChangeUserPasswordContext(context.Background(), name, oldPassword, newPassword, zone)
+}
+
+// ChangeUserPasswordContext is the same as ChangeUserPassword but the request is bound to the passed in context.Context
+func (conn *OnefsConn) ChangeUserPasswordContext(ctx context.Context, name string, oldPassword string, newPassword string, zone string) error {
+	body := struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}{
+		OldPassword: oldPassword,
+		NewPassword: newPassword,
+	}
+	if zone == "" {
+		zone = "System"
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"PUT",
+		conn.PlatformPath+"/auth/users/"+name+"/change-password",
+		map[string]string{"zone": zone},
+		body, // body
+		nil,  // extra headers
+		&struct{}{},
+	)
+	if err != nil {
+		conn.Logger().Error("[ChangeUserPassword] Error", "user", name, "zone", zone, "error", err)
+		return err
+	}
+	return nil
+}
+
+// UnlockUser unlocks a user account that was locked after too many failed login attempts
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) UnlockUser(name string, zone string) error {
+	return conn.UnlockUserContext(context.Background(), name, zone)
+}
+
+// UnlockUserContext is the same as UnlockUser but the request is bound to the passed in context.Context
+func (conn *OnefsConn) UnlockUserContext(ctx context.Context, name string, zone string) error {
+	unlock := true
+	return conn.ModifyUserContext(ctx, name, OnefsUserUpdate{Unlock: &unlock}, zone)
 }
 
 // GetUserList returns a list of OnefsUsers in a given access zone
 func (conn *OnefsConn) GetUserList(zone string) ([]OnefsUser, error) {
-	jsonObj, err := conn.Papi.Send(
+	return conn.GetUserListContext(context.Background(), zone)
+}
+
+// GetUserListContext is the same as GetUserList but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetUserListContext(ctx context.Context, zone string) ([]OnefsUser, error) {
+	var result struct {
+		Users []OnefsUser `json:"users"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
 		"GET",
 		conn.PlatformPath+"/auth/users",
 		map[string]string{"zone": zone},
 		nil, // body
 		nil, // extra headers
+		&result,
 	)
 	if err != nil {
 		return nil, err
 	}
-	//log.Print(fmt.Sprintf("[GetUserList] JSON: %s", debug_json(jsonObj)))
-	var result struct{ Users []OnefsUser }
-	err = mapstructure.Decode(jsonObj, &result)
-	if err != nil {
-		return nil, err
+	conn.Logger().Debug("[GetUserList] Response", "json", debugJSON(result))
+	return result.Users, nil
+}
+
+// OnefsUserIterator streams the users of an access zone one at a time. Users are fetched from the cluster one page
+// at a time as the iterator advances
+type OnefsUserIterator struct {
+	items *ItemIterator
+	user  *OnefsUser
+	err   error
+}
+
+// GetUserIterator returns an OnefsUserIterator over the users in a given access zone. This should be used instead of
+// GetUserList when the number of users is very large
+func (conn *OnefsConn) GetUserIterator(zone string) *OnefsUserIterator {
+	return conn.GetUserIteratorContext(context.Background(), zone)
+}
+
+// GetUserIteratorContext is the same as GetUserIterator but the requests are bound to the passed in context.Context
+func (conn *OnefsConn) GetUserIteratorContext(ctx context.Context, zone string) *OnefsUserIterator {
+	pager := conn.Papi.NewPagerContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/users",
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+	)
+	return &OnefsUserIterator{items: NewItemIterator(pager, "users")}
+}
+
+// Next advances the iterator to the next user. It returns false when there are no more users or an error occurred
+func (it *OnefsUserIterator) Next() bool {
+	it.user = nil
+	if it.err != nil || !it.items.Next() {
+		return false
+	}
+	var user OnefsUser
+	if err := decodeItem(it.items.Item(), &user); err != nil {
+		it.err = err
+		return false
 	}
-	return result.Users, err
+	it.user = &user
+	return true
+}
+
+// User returns the user selected by the last call to Next
+func (it *OnefsUserIterator) User() *OnefsUser {
+	return it.user
+}
+
+// Err returns the first error encountered by the iterator
+func (it *OnefsUserIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.items.Err()
 }
 
 // GetUser returns the OnefsUser structure for a specific user
 func (conn *OnefsConn) GetUser(name string, zone string) (*OnefsUser, error) {
-	jsonObj, err := conn.Papi.Send(
+	return conn.GetUserContext(context.Background(), name, zone)
+}
+
+// GetUserContext is the same as GetUser but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetUserContext(ctx context.Context, name string, zone string) (*OnefsUser, error) {
+	var result struct {
+		Users []OnefsUser `json:"users"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
 		"GET",
 		conn.PlatformPath+"/auth/users/"+name,
 		map[string]string{"query_member_of": "True", "zone": zone},
 		nil, // body
 		nil, // extra headers
+		&result,
 	)
 	if err != nil {
 		return nil, err
 	}
-	//log.Print(fmt.Sprintf("[GetUser] JSON: %s", debug_json(jsonObj)))
-	var result struct{ Users []OnefsUser }
-	err = mapstructure.Decode(jsonObj, &result)
-	if err != nil {
-		return nil, err
-	}
+	conn.Logger().Debug("[GetUser] Response", "json", debugJSON(result))
 	if len(result.Users) < 1 {
-		return nil, fmt.Errorf("[GetUser] User list was empty. Expected at least 1 user")
+		return nil, &emptyListError{"[GetUser] User list was empty. Expected at least 1 user"}
 	}
-	return &result.Users[0], err
+	return &result.Users[0], nil
 }
 
 // SetUserSuplementalGroups adds a list of groups to a user. This is done by repeated calls to AddUserToGroup
 func (conn *OnefsConn) SetUserSuplementalGroups(name string, groups []string, zone string) error {
+	return conn.SetUserSuplementalGroupsContext(context.Background(), name, groups, zone)
+}
+
+// SetUserSuplementalGroupsContext is the same as SetUserSuplementalGroups but the requests are bound to the passed in context.Context
+func (conn *OnefsConn) SetUserSuplementalGroupsContext(ctx context.Context, name string, groups []string, zone string) error {
 	errorCount := 0
 	for i := 0; i < len(groups); i++ {
-		_, err := conn.AddUserToGroup(name, groups[i], zone)
+		if ctx.Err() != nil {
+			return fmt.Errorf("[SetUserSuplementalGroups] Request aborted: %w", ctx.Err())
+		}
+		_, err := conn.AddUserToGroupContext(ctx, name, groups[i], zone)
 		if err != nil {
-			log.Print(fmt.Sprintf("Unable to add user %s to group %s in access zone %s", name, groups[i], zone))
+			conn.Logger().Warn("[SetUserSuplementalGroups] Unable to add user to group", "user", name, "group", groups[i], "zone", zone, "error", err)
 			errorCount++
 		}
 	}
@@ -97,58 +290,60 @@ func (conn *OnefsConn) SetUserSuplementalGroups(name string, groups []string, zo
 }
 
 // AddUserToGroup will add a supplementary groups to a user
+// If the user is already a member of the group the conflict returned by the API is ignored and no error is returned
 func (conn *OnefsConn) AddUserToGroup(name string, group string, zone string) (map[string]interface{}, error) {
+	return conn.AddUserToGroupContext(context.Background(), name, group, zone)
+}
+
+// AddUserToGroupContext is the same as AddUserToGroup but the request is bound to the passed in context.Context
+func (conn *OnefsConn) AddUserToGroupContext(ctx context.Context, name string, group string, zone string) (map[string]interface{}, error) {
 	body := OnefsID{
 		Name: name,
 		Type: "user",
 	}
-	bodyJSON, err := json.Marshal(body)
-	if err != nil {
-		return nil, err
-	}
-	//log.Print(fmt.Sprintf("[AddUserToGroup] Body of request: %s", bodyJSON))
-	jsonObj, err := conn.Papi.Send(
+	conn.Logger().Debug("[AddUserToGroup] Request", "body", debugJSON(body))
+	var jsonObj map[string]interface{}
+	err := conn.Papi.SendIntoContext(
+		ctx,
 		"POST",
 		conn.PlatformPath+"/auth/groups/"+group+"/members",
 		map[string]string{"zone": zone},
-		bodyJSON, // body
-		nil,      // extra headers
+		body, // body
+		nil,  // extra headers
+		&jsonObj,
 	)
 	if err != nil {
 		// For this call, some errors can be safely ignored. Specifically if the user is already a member of one of the groups passed in there is no problem
-		var apiErr struct{ Errors []OnefsError }
-		apiDecodeErr := mapstructure.Decode(err, &apiErr)
-		if apiDecodeErr != nil {
-			log.Print(fmt.Sprintf("[AddUserToGroup] Request error: %s", err))
-			return nil, err
-		}
-		duplicate := false
-		for i := 0; i < len(apiErr.Errors); i++ {
-			if apiErr.Errors[i].Code == "AEC_CONFLICT" {
-				duplicate = true
-			}
-		}
-		if !duplicate {
+		if !IsConflict(err) {
+			conn.Logger().Error("[AddUserToGroup] Request error", "user", name, "group", group, "zone", zone, "error", err)
 			return nil, err
 		}
+		return nil, nil
 	}
-	//log.Print(fmt.Sprintf("[AddUserToGroup] Response JSON: %s", debug_json(jsonObj)))
-	return jsonObj, err
+	conn.Logger().Debug("[AddUserToGroup] Response", "json", debugJSON(jsonObj))
+	return jsonObj, nil
 }
 
 // DeleteUser will delete a user
 func (conn *OnefsConn) DeleteUser(name string, zone string) (map[string]interface{}, error) {
-	jsonObj, err := conn.Papi.Send(
+	return conn.DeleteUserContext(context.Background(), name, zone)
+}
+
+// DeleteUserContext is the same as DeleteUser but the request is bound to the passed in context.Context
+func (conn *OnefsConn) DeleteUserContext(ctx context.Context, name string, zone string) (map[string]interface{}, error) {
+	var jsonObj map[string]interface{}
+	err := conn.Papi.SendIntoContext(
+		ctx,
 		"DELETE",
 		conn.PlatformPath+"/auth/users/"+name,
 		map[string]string{"zone": zone},
 		nil, // body
 		nil, // extra headers
+		&jsonObj,
 	)
-	//log.Print(fmt.Sprintf("[DeleteUser] JSON: %s", debug_json(jsonObj)))
 	if err != nil {
-		log.Print(fmt.Sprintf("[DeleteUser] Error: %s", err))
+		conn.Logger().Error("[DeleteUser] Error", "user", name, "zone", zone, "error", err)
 		return nil, err
 	}
-	return jsonObj, err
+	return jsonObj, nil
 }
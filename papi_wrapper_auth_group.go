@@ -0,0 +1,326 @@
+package papilite
+
+import (
+	"context"
+)
+
+// CreateGroup creates a new group in a given access zone
+// gid: GID of the new group. A GID is assigned automatically if gid is set to 0
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) CreateGroup(name string, gid int, zone string) (map[string]interface{}, error) {
+	return conn.CreateGroupContext(context.Background(), name, gid, zone)
+}
+
+// CreateGroupContext is the same as CreateGroup but the request is bound to the passed in context.Context
+func (conn *OnefsConn) CreateGroupContext(ctx context.Context, name string, gid int, zone string) (map[string]interface{}, error) {
+	body := struct {
+		Name string `json:"name"`
+		GID  int    `json:"gid,omitempty"`
+	}{
+		Name: name,
+		GID:  gid,
+	}
+	if zone == "" {
+		zone = "System"
+	}
+	var result map[string]interface{}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"POST",
+		conn.PlatformPath+"/auth/groups",
+		map[string]string{"force": "True", "zone": zone},
+		body, // body
+		nil,  // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetGroupList returns a list of OnefsGroups in a given access zone
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) GetGroupList(zone string) ([]OnefsGroup, error) {
+	return conn.GetGroupListContext(context.Background(), zone)
+}
+
+// GetGroupListContext is the same as GetGroupList but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetGroupListContext(ctx context.Context, zone string) ([]OnefsGroup, error) {
+	if zone == "" {
+		zone = "System"
+	}
+	var result struct {
+		Groups []OnefsGroup `json:"groups"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/groups",
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[GetGroupList] Response", "json", debugJSON(result))
+	return result.Groups, nil
+}
+
+// GetGroup returns the OnefsGroup structure for a specific group including the groups it is a member of
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) GetGroup(name string, zone string) (*OnefsGroup, error) {
+	return conn.GetGroupContext(context.Background(), name, zone)
+}
+
+// GetGroupContext is the same as GetGroup but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetGroupContext(ctx context.Context, name string, zone string) (*OnefsGroup, error) {
+	if zone == "" {
+		zone = "System"
+	}
+	var result struct {
+		Groups []OnefsGroup `json:"groups"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/groups/"+name,
+		map[string]string{"query_member_of": "True", "zone": zone},
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[GetGroup] Response", "json", debugJSON(result))
+	if len(result.Groups) < 1 {
+		return nil, &emptyListError{"[GetGroup] Group list was empty. Expected at least 1 group"}
+	}
+	return &result.Groups[0], nil
+}
+
+// ModifyGroup changes the GID or SID of a group. Only the fields of update that are not nil are changed
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) ModifyGroup(name string, update OnefsGroupUpdate, zone string) error {
+	return conn.ModifyGroupContext(context.Background(), name, update, zone)
+}
+
+// ModifyGroupContext is the same as ModifyGroup but the request is bound to the passed in context.Context
+func (conn *OnefsConn) ModifyGroupContext(ctx context.Context, name string, update OnefsGroupUpdate, zone string) error {
+	if zone == "" {
+		zone = "System"
+	}
+	conn.Logger().Debug("[ModifyGroup] Request", "body", debugJSON(update))
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"PUT",
+		conn.PlatformPath+"/auth/groups/"+name,
+		map[string]string{"force": "True", "zone": zone},
+		update, // body
+		nil,    // extra headers
+		&struct{}{},
+	)
+	if err != nil {
+		conn.Logger().Error("[ModifyGroup] Error", "group", name, "zone", zone, "error", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteGroup will delete a group
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) DeleteGroup(name string, zone string) (map[string]interface{}, error) {
+	return conn.DeleteGroupContext(context.Background(), name, zone)
+}
+
+// DeleteGroupContext is the same as DeleteGroup but the request is bound to the passed in context.Context
+func (conn *OnefsConn) DeleteGroupContext(ctx context.Context, name string, zone string) (map[string]interface{}, error) {
+	if zone == "" {
+		zone = "System"
+	}
+	var jsonObj map[string]interface{}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"DELETE",
+		conn.PlatformPath+"/auth/groups/"+name,
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&jsonObj,
+	)
+	if err != nil {
+		conn.Logger().Error("[DeleteGroup] Error", "group", name, "zone", zone, "error", err)
+		return nil, err
+	}
+	return jsonObj, nil
+}
+
+// GetGroupMembers returns the users and groups that are direct members of a group
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) GetGroupMembers(name string, zone string) ([]OnefsID, error) {
+	return conn.GetGroupMembersContext(context.Background(), name, zone)
+}
+
+// GetGroupMembersContext is the same as GetGroupMembers but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetGroupMembersContext(ctx context.Context, name string, zone string) ([]OnefsID, error) {
+	if zone == "" {
+		zone = "System"
+	}
+	var result struct {
+		Members []OnefsID `json:"members"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/groups/"+name+"/members",
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[GetGroupMembers] Response", "json", debugJSON(result))
+	return result.Members, nil
+}
+
+// GetGroupMembersRecursive returns the users that are members of a group either directly or through nested groups.
+// Every user is only returned once and nested groups that are members of each other are only expanded once
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) GetGroupMembersRecursive(name string, zone string) ([]OnefsID, error) {
+	return conn.GetGroupMembersRecursiveContext(context.Background(), name, zone)
+}
+
+// GetGroupMembersRecursiveContext is the same as GetGroupMembersRecursive but the requests are bound to the passed in context.Context
+func (conn *OnefsConn) GetGroupMembersRecursiveContext(ctx context.Context, name string, zone string) ([]OnefsID, error) {
+	var users []OnefsID
+	seenUsers := map[string]bool{}
+	seenGroups := map[string]bool{name: true}
+	pending := []string{name}
+	for len(pending) > 0 {
+		group := pending[0]
+		pending = pending[1:]
+		members, err := conn.GetGroupMembersContext(ctx, group, zone)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if member.Type == "group" {
+				key := member.Name
+				if key == "" {
+					key = member.ID
+				}
+				if !seenGroups[key] {
+					seenGroups[key] = true
+					pending = append(pending, key)
+				}
+				continue
+			}
+			key := member.ID
+			if key == "" {
+				key = member.Type + ":" + member.Name
+			}
+			if !seenUsers[key] {
+				seenUsers[key] = true
+				users = append(users, member)
+			}
+		}
+	}
+	return users, nil
+}
+
+// RemoveUserFromGroup will remove a user from a supplementary group. This is the inverse of AddUserToGroup
+// If the user is not a member of the group the not found error returned by the API is ignored and no error is returned.
+// A not found error is still returned if the group does not exist
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) RemoveUserFromGroup(name string, group string, zone string) error {
+	return conn.RemoveUserFromGroupContext(context.Background(), name, group, zone)
+}
+
+// RemoveUserFromGroupContext is the same as RemoveUserFromGroup but the requests are bound to the passed in context.Context
+func (conn *OnefsConn) RemoveUserFromGroupContext(ctx context.Context, name string, group string, zone string) error {
+	return conn.removeGroupMember(ctx, "[RemoveUserFromGroup]", "USER:"+name, group, zone)
+}
+
+// AddGroupToGroup will add a group as a nested member of another group
+// If the group is already a member of the parent group the conflict returned by the API is ignored and no error is returned
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) AddGroupToGroup(name string, parent string, zone string) (map[string]interface{}, error) {
+	return conn.AddGroupToGroupContext(context.Background(), name, parent, zone)
+}
+
+// AddGroupToGroupContext is the same as AddGroupToGroup but the request is bound to the passed in context.Context
+func (conn *OnefsConn) AddGroupToGroupContext(ctx context.Context, name string, parent string, zone string) (map[string]interface{}, error) {
+	if zone == "" {
+		zone = "System"
+	}
+	body := OnefsID{
+		Name: name,
+		Type: "group",
+	}
+	conn.Logger().Debug("[AddGroupToGroup] Request", "body", debugJSON(body))
+	var jsonObj map[string]interface{}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"POST",
+		conn.PlatformPath+"/auth/groups/"+parent+"/members",
+		map[string]string{"zone": zone},
+		body, // body
+		nil,  // extra headers
+		&jsonObj,
+	)
+	if err != nil {
+		if !IsConflict(err) {
+			conn.Logger().Error("[AddGroupToGroup] Request error", "group", name, "parent", parent, "zone", zone, "error", err)
+			return nil, err
+		}
+		return nil, nil
+	}
+	conn.Logger().Debug("[AddGroupToGroup] Response", "json", debugJSON(jsonObj))
+	return jsonObj, nil
+}
+
+// RemoveGroupFromGroup will remove a nested group from another group. This is the inverse of AddGroupToGroup
+// If the group is not a member of the parent group the not found error returned by the API is ignored and no error is
+// returned. A not found error is still returned if the parent group does not exist
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) RemoveGroupFromGroup(name string, parent string, zone string) error {
+	return conn.RemoveGroupFromGroupContext(context.Background(), name, parent, zone)
+}
+
+// RemoveGroupFromGroupContext is the same as RemoveGroupFromGroup but the requests are bound to the passed in context.Context
+func (conn *OnefsConn) RemoveGroupFromGroupContext(ctx context.Context, name string, parent string, zone string) error {
+	return conn.removeGroupMember(ctx, "[RemoveGroupFromGroup]", "GROUP:"+name, parent, zone)
+}
+
+// removeGroupMember removes a persona given as USER:<name> or GROUP:<name> from a group
+func (conn *OnefsConn) removeGroupMember(ctx context.Context, caller string, member string, group string, zone string) error {
+	if zone == "" {
+		zone = "System"
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"DELETE",
+		conn.PlatformPath+"/auth/groups/"+group+"/members/"+member,
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&struct{}{},
+	)
+	if err != nil {
+		// The persona not being a member of the group is the desired end state so the error can be ignored. The same
+		// error is returned for a missing group so the group is checked before the error is ignored
+		if IsNotFound(err) {
+			if _, groupErr := conn.GetGroupContext(ctx, group, zone); groupErr == nil {
+				return nil
+			}
+		}
+		conn.Logger().Error(caller+" Request error", "member", member, "group", group, "zone", zone, "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package papilite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// LookupUserMapping returns the access token of a user in a given access zone. The token contains the UID, SID and
+// on disk identity of the user, all the groups of the user with their GIDs and SIDs and the privileges granted through
+// RBAC roles. This is the identity OneFS uses when checking file and API access for the user
+// user: Name of the user. The user can also be given as a persona like UID:2000 or SID:S-1-5-21-...
+func (conn *OnefsConn) LookupUserMapping(user string, zone string) (*OnefsAccessToken, error) {
+	return conn.LookupUserMappingContext(context.Background(), user, zone)
+}
+
+// LookupUserMappingContext is the same as LookupUserMapping but the request is bound to the passed in context.Context
+func (conn *OnefsConn) LookupUserMappingContext(ctx context.Context, user string, zone string) (*OnefsAccessToken, error) {
+	var result struct {
+		Mapping []OnefsAccessToken `json:"mapping"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/mapping/users/lookup",
+		map[string]string{"user": user, "zone": zone},
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[LookupUserMapping] Response", "json", debugJSON(result))
+	if len(result.Mapping) < 1 {
+		return nil, &emptyListError{"[LookupUserMapping] Mapping list was empty. Expected at least 1 mapping"}
+	}
+	return &result.Mapping[0], nil
+}
+
+// GetIdentityMappingList returns all the identity mappings in a given access zone
+func (conn *OnefsConn) GetIdentityMappingList(zone string) ([]OnefsIdentityMapping, error) {
+	return conn.GetIdentityMappingListContext(context.Background(), zone)
+}
+
+// GetIdentityMappingListContext is the same as GetIdentityMappingList but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetIdentityMappingListContext(ctx context.Context, zone string) ([]OnefsIdentityMapping, error) {
+	var result struct {
+		Identities []OnefsIdentityMapping `json:"identities"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/mapping/identities",
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[GetIdentityMappingList] Response", "json", debugJSON(result))
+	return result.Identities, nil
+}
+
+// GetIdentityMapping returns the identity mappings of a single source persona like UID:2000 or SID:S-1-5-21-...
+// No mapping is generated for a source persona without existing mappings. A not found error is returned instead
+func (conn *OnefsConn) GetIdentityMapping(source string, zone string) (*OnefsIdentityMapping, error) {
+	return conn.GetIdentityMappingContext(context.Background(), source, zone)
+}
+
+// GetIdentityMappingContext is the same as GetIdentityMapping but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetIdentityMappingContext(ctx context.Context, source string, zone string) (*OnefsIdentityMapping, error) {
+	var result struct {
+		Identities []OnefsIdentityMapping `json:"identities"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/mapping/identities/"+source,
+		map[string]string{"nocreate": "True", "zone": zone},
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[GetIdentityMapping] Response", "json", debugJSON(result))
+	if len(result.Identities) < 1 {
+		return nil, &emptyListError{"[GetIdentityMapping] Identity list was empty. Expected at least 1 identity"}
+	}
+	return &result.Identities[0], nil
+}
+
+// CreateIdentityMapping creates a mapping from a source persona to a target persona, e.g. from UID:2000 to
+// SID:S-1-5-21-...
+// twoWay: When true the mapping is created in both directions and the personas are treated as equivalent
+// replace: When true any existing mappings of the source persona are replaced
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) CreateIdentityMapping(source string, target string, twoWay bool, replace bool, zone string) error {
+	return conn.CreateIdentityMappingContext(context.Background(), source, target, twoWay, replace, zone)
+}
+
+// CreateIdentityMappingContext is the same as CreateIdentityMapping but the request is bound to the passed in context.Context
+func (conn *OnefsConn) CreateIdentityMappingContext(ctx context.Context, source string, target string, twoWay bool, replace bool, zone string) error {
+	body := struct {
+		Source string `json:"source"`
+		Target string `json:"target"`
+	}{
+		Source: source,
+		Target: target,
+	}
+	if zone == "" {
+		zone = "System"
+	}
+	query := map[string]string{"zone": zone}
+	if twoWay {
+		query["2way"] = "True"
+	}
+	if replace {
+		query["replace"] = "True"
+	}
+	conn.Logger().Debug("[CreateIdentityMapping] Request", "body", debugJSON(body))
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"POST",
+		conn.PlatformPath+"/auth/mapping/identities",
+		query,
+		body, // body
+		nil,  // extra headers
+		&struct{}{},
+	)
+	if err != nil {
+		conn.Logger().Error("[CreateIdentityMapping] Error", "source", source, "target", target, "zone", zone, "error", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteIdentityMapping removes the mapping from a source persona to a target persona. All the mappings of the source
+// persona are removed if target is empty. When twoWay is true the mappings in the reverse direction are removed as well
+// A not found error is returned if the source persona has no mappings. The API returns the same error for a missing
+// access zone so the error is not ignored. Use IsNotFound to treat a missing mapping as success
+func (conn *OnefsConn) DeleteIdentityMapping(source string, target string, twoWay bool, zone string) error {
+	return conn.DeleteIdentityMappingContext(context.Background(), source, target, twoWay, zone)
+}
+
+// DeleteIdentityMappingContext is the same as DeleteIdentityMapping but the request is bound to the passed in context.Context
+func (conn *OnefsConn) DeleteIdentityMappingContext(ctx context.Context, source string, target string, twoWay bool, zone string) error {
+	query := map[string]string{"zone": zone}
+	if target != "" {
+		query["target"] = target
+	}
+	if twoWay {
+		query["2way"] = "True"
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"DELETE",
+		conn.PlatformPath+"/auth/mapping/identities/"+source,
+		query,
+		nil, // body
+		nil, // extra headers
+		&struct{}{},
+	)
+	if err != nil {
+		// Callers commonly treat a missing mapping as success so it is not logged as an error
+		if !IsNotFound(err) {
+			conn.Logger().Error("[DeleteIdentityMapping] Error", "source", source, "target", target, "zone", zone, "error", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetUserMappingRules returns the user mapping rules of a given access zone in the order they are applied
+func (conn *OnefsConn) GetUserMappingRules(zone string) ([]OnefsUserMappingRule, error) {
+	return conn.GetUserMappingRulesContext(context.Background(), zone)
+}
+
+// GetUserMappingRulesContext is the same as GetUserMappingRules but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetUserMappingRulesContext(ctx context.Context, zone string) ([]OnefsUserMappingRule, error) {
+	var result struct {
+		Rules struct {
+			Rules []OnefsUserMappingRule `json:"rules"`
+		} `json:"rules"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/mapping/users/rules",
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[GetUserMappingRules] Response", "json", debugJSON(result))
+	return result.Rules.Rules, nil
+}
+
+// SetUserMappingRules replaces the user mapping rules of a given access zone. The rules are applied in the order of
+// the slice. An empty slice removes all the rules
+func (conn *OnefsConn) SetUserMappingRules(rules []OnefsUserMappingRule, zone string) error {
+	return conn.SetUserMappingRulesContext(context.Background(), rules, zone)
+}
+
+// SetUserMappingRulesContext is the same as SetUserMappingRules but the request is bound to the passed in context.Context
+func (conn *OnefsConn) SetUserMappingRulesContext(ctx context.Context, rules []OnefsUserMappingRule, zone string) error {
+	if rules == nil {
+		rules = []OnefsUserMappingRule{}
+	}
+	body := map[string]interface{}{
+		"rules": map[string]interface{}{
+			"rules": rules,
+		},
+	}
+	conn.Logger().Debug("[SetUserMappingRules] Request", "body", debugJSON(body))
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"PUT",
+		conn.PlatformPath+"/auth/mapping/users/rules",
+		map[string]string{"zone": zone},
+		body, // body
+		nil,  // extra headers
+		&struct{}{},
+	)
+	if err != nil {
+		conn.Logger().Error("[SetUserMappingRules] Error", "zone", zone, "error", err)
+		return err
+	}
+	return nil
+}
+
+// ResolvePersona converts between the names, UIDs, GIDs and SIDs of users and groups in a given access zone
+// persona: The persona to resolve. This can be USER:<name>, GROUP:<name>, UID:<uid>, GID:<gid> or SID:<sid>. A SID or
+// a plain name without a prefix is looked up as a user first and as a group if no user is found
+func (conn *OnefsConn) ResolvePersona(persona string, zone string) (*OnefsPersona, error) {
+	return conn.ResolvePersonaContext(context.Background(), persona, zone)
+}
+
+// ResolvePersonaContext is the same as ResolvePersona but the requests are bound to the passed in context.Context
+func (conn *OnefsConn) ResolvePersonaContext(ctx context.Context, persona string, zone string) (*OnefsPersona, error) {
+	prefix := ""
+	if i := strings.Index(persona, ":"); i >= 0 {
+		prefix = strings.ToUpper(persona[:i])
+	}
+	switch prefix {
+	case "USER", "UID":
+		return conn.resolveUserPersona(ctx, persona, zone)
+	case "GROUP", "GID":
+		return conn.resolveGroupPersona(ctx, persona, zone)
+	case "SID", "":
+		result, err := conn.resolveUserPersona(ctx, persona, zone)
+		if err == nil || !IsNotFound(err) {
+			return result, err
+		}
+		return conn.resolveGroupPersona(ctx, persona, zone)
+	}
+	return nil, fmt.Errorf("[ResolvePersona] Unsupported persona type %s in: %s", prefix, persona)
+}
+
+// resolveUserPersona returns the identities of a user given as a name or a persona
+func (conn *OnefsConn) resolveUserPersona(ctx context.Context, persona string, zone string) (*OnefsPersona, error) {
+	user, err := conn.GetUserContext(ctx, persona, zone)
+	if err != nil {
+		return nil, err
+	}
+	return &OnefsPersona{
+		Name: user.Name,
+		SID:  user.SID,
+		Type: "user",
+		UID:  user.UID,
+	}, nil
+}
+
+// resolveGroupPersona returns the identities of a group given as a name or a persona
+func (conn *OnefsConn) resolveGroupPersona(ctx context.Context, persona string, zone string) (*OnefsPersona, error) {
+	group, err := conn.GetGroupContext(ctx, persona, zone)
+	if err != nil {
+		return nil, err
+	}
+	return &OnefsPersona{
+		GID:  group.GID,
+		Name: group.Name,
+		SID:  group.SID,
+		Type: "group",
+	}, nil
+}
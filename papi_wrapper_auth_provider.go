@@ -0,0 +1,460 @@
+package papilite
+
+import (
+	"context"
+	"fmt"
+)
+
+// Authentication provider types used in the paths of the provider API
+const (
+	AuthProviderAds   string = "ads"
+	AuthProviderLdap  string = "ldap"
+	AuthProviderNis   string = "nis"
+	AuthProviderFile  string = "file"
+	AuthProviderLocal string = "local"
+)
+
+// AuthProviderOnline is the status of an authentication provider that is working normally
+const AuthProviderOnline string = "online"
+
+// getAuthProviders gets the providers of a type and decodes the response into out. When name is set only that
+// provider is requested. When zone is set the request is sent for that access zone, which limits a list to the
+// providers of the access zone
+func (conn *OnefsConn) getAuthProviders(ctx context.Context, caller string, providerType string, name string, zone string, out interface{}) error {
+	path := conn.PlatformPath + "/auth/providers/" + providerType
+	if name != "" {
+		path += "/" + name
+	}
+	var query map[string]string
+	if zone != "" {
+		query = map[string]string{"zone": zone}
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		path,
+		query,
+		nil, // body
+		nil, // extra headers
+		out,
+	)
+	if err != nil {
+		return err
+	}
+	conn.Logger().Debug(caller+" Response", "json", debugJSON(out))
+	return nil
+}
+
+// checkProviderFound returns a not found error when a request for a single provider returned an empty provider list
+func checkProviderFound(caller string, count int) error {
+	if count < 1 {
+		return &emptyListError{caller + " Provider list was empty. Expected at least 1 provider"}
+	}
+	return nil
+}
+
+// GetAdsProviderList returns all Active Directory authentication providers of the cluster
+func (conn *OnefsConn) GetAdsProviderList() ([]OnefsAdsProvider, error) {
+	return conn.GetAdsProviderListContext(context.Background())
+}
+
+// GetAdsProviderListContext is the same as GetAdsProviderList but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetAdsProviderListContext(ctx context.Context) ([]OnefsAdsProvider, error) {
+	var result struct {
+		Providers []OnefsAdsProvider `json:"ads"`
+	}
+	if err := conn.getAuthProviders(ctx, "[GetAdsProviderList]", AuthProviderAds, "", "", &result); err != nil {
+		return nil, err
+	}
+	return result.Providers, nil
+}
+
+// GetAdsProvider returns the configuration and status of a specific Active Directory authentication provider
+func (conn *OnefsConn) GetAdsProvider(name string) (*OnefsAdsProvider, error) {
+	return conn.GetAdsProviderContext(context.Background(), name)
+}
+
+// GetAdsProviderContext is the same as GetAdsProvider but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetAdsProviderContext(ctx context.Context, name string) (*OnefsAdsProvider, error) {
+	var result struct {
+		Providers []OnefsAdsProvider `json:"ads"`
+	}
+	if err := conn.getAuthProviders(ctx, "[GetAdsProvider]", AuthProviderAds, name, "", &result); err != nil {
+		return nil, err
+	}
+	if err := checkProviderFound("[GetAdsProvider]", len(result.Providers)); err != nil {
+		return nil, err
+	}
+	return &result.Providers[0], nil
+}
+
+// GetLdapProviderList returns the LDAP authentication providers. If zone is not empty only the providers of the
+// access zone are returned
+func (conn *OnefsConn) GetLdapProviderList(zone string) ([]OnefsLdapProvider, error) {
+	return conn.GetLdapProviderListContext(context.Background(), zone)
+}
+
+// GetLdapProviderListContext is the same as GetLdapProviderList but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetLdapProviderListContext(ctx context.Context, zone string) ([]OnefsLdapProvider, error) {
+	var result struct {
+		Providers []OnefsLdapProvider `json:"ldap"`
+	}
+	if err := conn.getAuthProviders(ctx, "[GetLdapProviderList]", AuthProviderLdap, "", zone, &result); err != nil {
+		return nil, err
+	}
+	return result.Providers, nil
+}
+
+// GetLdapProvider returns the configuration and status of a specific LDAP authentication provider
+// zone: Access zone of the provider. Ignored if the string is empty
+func (conn *OnefsConn) GetLdapProvider(name string, zone string) (*OnefsLdapProvider, error) {
+	return conn.GetLdapProviderContext(context.Background(), name, zone)
+}
+
+// GetLdapProviderContext is the same as GetLdapProvider but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetLdapProviderContext(ctx context.Context, name string, zone string) (*OnefsLdapProvider, error) {
+	var result struct {
+		Providers []OnefsLdapProvider `json:"ldap"`
+	}
+	if err := conn.getAuthProviders(ctx, "[GetLdapProvider]", AuthProviderLdap, name, zone, &result); err != nil {
+		return nil, err
+	}
+	if err := checkProviderFound("[GetLdapProvider]", len(result.Providers)); err != nil {
+		return nil, err
+	}
+	return &result.Providers[0], nil
+}
+
+// GetNisProviderList returns the NIS authentication providers. If zone is not empty only the providers of the
+// access zone are returned
+func (conn *OnefsConn) GetNisProviderList(zone string) ([]OnefsNisProvider, error) {
+	return conn.GetNisProviderListContext(context.Background(), zone)
+}
+
+// GetNisProviderListContext is the same as GetNisProviderList but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetNisProviderListContext(ctx context.Context, zone string) ([]OnefsNisProvider, error) {
+	var result struct {
+		Providers []OnefsNisProvider `json:"nis"`
+	}
+	if err := conn.getAuthProviders(ctx, "[GetNisProviderList]", AuthProviderNis, "", zone, &result); err != nil {
+		return nil, err
+	}
+	return result.Providers, nil
+}
+
+// GetNisProvider returns the configuration and status of a specific NIS authentication provider
+// zone: Access zone of the provider. Ignored if the string is empty
+func (conn *OnefsConn) GetNisProvider(name string, zone string) (*OnefsNisProvider, error) {
+	return conn.GetNisProviderContext(context.Background(), name, zone)
+}
+
+// GetNisProviderContext is the same as GetNisProvider but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetNisProviderContext(ctx context.Context, name string, zone string) (*OnefsNisProvider, error) {
+	var result struct {
+		Providers []OnefsNisProvider `json:"nis"`
+	}
+	if err := conn.getAuthProviders(ctx, "[GetNisProvider]", AuthProviderNis, name, zone, &result); err != nil {
+		return nil, err
+	}
+	if err := checkProviderFound("[GetNisProvider]", len(result.Providers)); err != nil {
+		return nil, err
+	}
+	return &result.Providers[0], nil
+}
+
+// GetFileProviderList returns the file authentication providers. If zone is not empty only the providers of the
+// access zone are returned
+func (conn *OnefsConn) GetFileProviderList(zone string) ([]OnefsFileProvider, error) {
+	return conn.GetFileProviderListContext(context.Background(), zone)
+}
+
+// GetFileProviderListContext is the same as GetFileProviderList but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetFileProviderListContext(ctx context.Context, zone string) ([]OnefsFileProvider, error) {
+	var result struct {
+		Providers []OnefsFileProvider `json:"file"`
+	}
+	if err := conn.getAuthProviders(ctx, "[GetFileProviderList]", AuthProviderFile, "", zone, &result); err != nil {
+		return nil, err
+	}
+	return result.Providers, nil
+}
+
+// GetFileProvider returns the configuration and status of a specific file authentication provider
+// zone: Access zone of the provider. Ignored if the string is empty
+func (conn *OnefsConn) GetFileProvider(name string, zone string) (*OnefsFileProvider, error) {
+	return conn.GetFileProviderContext(context.Background(), name, zone)
+}
+
+// GetFileProviderContext is the same as GetFileProvider but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetFileProviderContext(ctx context.Context, name string, zone string) (*OnefsFileProvider, error) {
+	var result struct {
+		Providers []OnefsFileProvider `json:"file"`
+	}
+	if err := conn.getAuthProviders(ctx, "[GetFileProvider]", AuthProviderFile, name, zone, &result); err != nil {
+		return nil, err
+	}
+	if err := checkProviderFound("[GetFileProvider]", len(result.Providers)); err != nil {
+		return nil, err
+	}
+	return &result.Providers[0], nil
+}
+
+// GetLocalProviderList returns the local authentication providers. If zone is not empty only the providers of the
+// access zone are returned
+func (conn *OnefsConn) GetLocalProviderList(zone string) ([]OnefsLocalProvider, error) {
+	return conn.GetLocalProviderListContext(context.Background(), zone)
+}
+
+// GetLocalProviderListContext is the same as GetLocalProviderList but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetLocalProviderListContext(ctx context.Context, zone string) ([]OnefsLocalProvider, error) {
+	var result struct {
+		Providers []OnefsLocalProvider `json:"local"`
+	}
+	if err := conn.getAuthProviders(ctx, "[GetLocalProviderList]", AuthProviderLocal, "", zone, &result); err != nil {
+		return nil, err
+	}
+	return result.Providers, nil
+}
+
+// GetLocalProvider returns the configuration and status of a specific local authentication provider
+// zone: Access zone of the provider. Ignored if the string is empty
+func (conn *OnefsConn) GetLocalProvider(name string, zone string) (*OnefsLocalProvider, error) {
+	return conn.GetLocalProviderContext(context.Background(), name, zone)
+}
+
+// GetLocalProviderContext is the same as GetLocalProvider but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetLocalProviderContext(ctx context.Context, name string, zone string) (*OnefsLocalProvider, error) {
+	var result struct {
+		Providers []OnefsLocalProvider `json:"local"`
+	}
+	if err := conn.getAuthProviders(ctx, "[GetLocalProvider]", AuthProviderLocal, name, zone, &result); err != nil {
+		return nil, err
+	}
+	if err := checkProviderFound("[GetLocalProvider]", len(result.Providers)); err != nil {
+		return nil, err
+	}
+	return &result.Providers[0], nil
+}
+
+// CreateAuthProvider creates a new authentication provider of the given type, e.g. AuthProviderLdap. settings contains
+// the provider configuration as documented in the API for the provider type and must contain at least the name
+// zone: Access zone the provider is created in. Ignored if the string is empty
+func (conn *OnefsConn) CreateAuthProvider(providerType string, settings map[string]interface{}, zone string) (map[string]interface{}, error) {
+	return conn.CreateAuthProviderContext(context.Background(), providerType, settings, zone)
+}
+
+// CreateAuthProviderContext is the same as CreateAuthProvider but the request is bound to the passed in context.Context
+func (conn *OnefsConn) CreateAuthProviderContext(ctx context.Context, providerType string, settings map[string]interface{}, zone string) (map[string]interface{}, error) {
+	var query map[string]string
+	if zone != "" {
+		query = map[string]string{"zone": zone}
+	}
+	conn.Logger().Debug("[CreateAuthProvider] Request", "type", providerType, "body", debugJSON(settings))
+	var result map[string]interface{}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"POST",
+		conn.PlatformPath+"/auth/providers/"+providerType,
+		query,
+		settings, // body
+		nil,      // extra headers
+		&result,
+	)
+	if err != nil {
+		conn.Logger().Error("[CreateAuthProvider] Error", "type", providerType, "zone", zone, "error", err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// ModifyAuthProvider changes the configuration of an authentication provider. Only the keys in settings are changed
+// zone: Access zone of the provider. Ignored if the string is empty
+func (conn *OnefsConn) ModifyAuthProvider(providerType string, name string, settings map[string]interface{}, zone string) error {
+	return conn.ModifyAuthProviderContext(context.Background(), providerType, name, settings, zone)
+}
+
+// ModifyAuthProviderContext is the same as ModifyAuthProvider but the request is bound to the passed in context.Context
+func (conn *OnefsConn) ModifyAuthProviderContext(ctx context.Context, providerType string, name string, settings map[string]interface{}, zone string) error {
+	var query map[string]string
+	if zone != "" {
+		query = map[string]string{"zone": zone}
+	}
+	conn.Logger().Debug("[ModifyAuthProvider] Request", "type", providerType, "name", name, "body", debugJSON(settings))
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"PUT",
+		conn.PlatformPath+"/auth/providers/"+providerType+"/"+name,
+		query,
+		settings, // body
+		nil,      // extra headers
+		&struct{}{},
+	)
+	if err != nil {
+		conn.Logger().Error("[ModifyAuthProvider] Error", "type", providerType, "name", name, "zone", zone, "error", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteAuthProvider deletes an authentication provider. Deleting an Active Directory provider leaves the domain
+// zone: Access zone of the provider. Ignored if the string is empty
+func (conn *OnefsConn) DeleteAuthProvider(providerType string, name string, zone string) error {
+	return conn.DeleteAuthProviderContext(context.Background(), providerType, name, zone)
+}
+
+// DeleteAuthProviderContext is the same as DeleteAuthProvider but the request is bound to the passed in context.Context
+func (conn *OnefsConn) DeleteAuthProviderContext(ctx context.Context, providerType string, name string, zone string) error {
+	var query map[string]string
+	if zone != "" {
+		query = map[string]string{"zone": zone}
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"DELETE",
+		conn.PlatformPath+"/auth/providers/"+providerType+"/"+name,
+		query,
+		nil, // body
+		nil, // extra headers
+		&struct{}{},
+	)
+	if err != nil {
+		conn.Logger().Error("[DeleteAuthProvider] Error", "type", providerType, "name", name, "zone", zone, "error", err)
+		return err
+	}
+	return nil
+}
+
+// JoinAdsDomain joins the cluster to an Active Directory domain by creating an Active Directory provider
+// domain: Fully qualified name of the domain
+// user and password: Credentials of a domain account that is allowed to join machines to the domain
+// settings: Optional additional provider settings, e.g. machine_account or organizational_unit. Can be nil
+func (conn *OnefsConn) JoinAdsDomain(domain string, user string, password string, settings map[string]interface{}) (map[string]interface{}, error) {
+	return conn.JoinAdsDomainContext(context.Background(), domain, user, password, settings)
+}
+
+// JoinAdsDomainContext is the same as JoinAdsDomain but the request is bound to the passed in context.Context
+func (conn *OnefsConn) JoinAdsDomainContext(ctx context.Context, domain string, user string, password string, settings map[string]interface{}) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	for k, v := range settings {
+		body[k] = v
+	}
+	body["name"] = domain
+	body["user"] = user
+	body["password"] = password
+	return conn.CreateAuthProviderContext(ctx, AuthProviderAds, body, "")
+}
+
+// LeaveAdsDomain removes the cluster from an Active Directory domain by deleting the Active Directory provider
+func (conn *OnefsConn) LeaveAdsDomain(name string) error {
+	return conn.LeaveAdsDomainContext(context.Background(), name)
+}
+
+// LeaveAdsDomainContext is the same as LeaveAdsDomain but the request is bound to the passed in context.Context
+func (conn *OnefsConn) LeaveAdsDomainContext(ctx context.Context, name string) error {
+	return conn.DeleteAuthProviderContext(ctx, AuthProviderAds, name, "")
+}
+
+// CheckAdsSPNs checks the service principal names registered for the cluster in an Active Directory domain. Missing
+// in the result lists the names that should be registered but are not
+func (conn *OnefsConn) CheckAdsSPNs(name string) (*OnefsAdsSPNCheck, error) {
+	return conn.CheckAdsSPNsContext(context.Background(), name)
+}
+
+// CheckAdsSPNsContext is the same as CheckAdsSPNs but the request is bound to the passed in context.Context
+func (conn *OnefsConn) CheckAdsSPNsContext(ctx context.Context, name string) (*OnefsAdsSPNCheck, error) {
+	var result struct {
+		SPNs OnefsAdsSPNCheck `json:"spns"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/ads/"+name+"/spn",
+		nil, // query args
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[CheckAdsSPNs] Response", "json", debugJSON(result))
+	return &result.SPNs, nil
+}
+
+// GetAuthProviderSummary returns the status of the authentication providers. If zone is not empty only the providers
+// of the access zone are returned
+func (conn *OnefsConn) GetAuthProviderSummary(zone string) ([]OnefsAuthProviderStatus, error) {
+	return conn.GetAuthProviderSummaryContext(context.Background(), zone)
+}
+
+// GetAuthProviderSummaryContext is the same as GetAuthProviderSummary but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetAuthProviderSummaryContext(ctx context.Context, zone string) ([]OnefsAuthProviderStatus, error) {
+	var query map[string]string
+	if zone != "" {
+		query = map[string]string{"zone": zone}
+	}
+	var result struct {
+		ProviderInstances []OnefsAuthProviderStatus `json:"provider_instances"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/providers/summary",
+		query,
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[GetAuthProviderSummary] Response", "json", debugJSON(result))
+	return result.ProviderInstances, nil
+}
+
+// CheckZoneAuthProviders checks that every authentication provider configured for an access zone is online. The
+// returned map contains an entry for every provider ID of the zone with a nil error for providers that are online.
+// The error is only set if the status of the providers could not be retrieved
+func (conn *OnefsConn) CheckZoneAuthProviders(zone string) (map[string]error, error) {
+	return conn.CheckZoneAuthProvidersContext(context.Background(), zone)
+}
+
+// CheckZoneAuthProvidersContext is the same as CheckZoneAuthProviders but the requests are bound to the passed in context.Context
+func (conn *OnefsConn) CheckZoneAuthProvidersContext(ctx context.Context, zone string) (map[string]error, error) {
+	zones, err := conn.GetAccessZoneListContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var providers []string
+	found := false
+	for _, z := range zones {
+		if z.Name == zone {
+			providers = z.AuthProviders
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("[CheckZoneAuthProviders] Access zone not found: %s", zone)
+	}
+	summary, err := conn.GetAuthProviderSummaryContext(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	status := map[string]string{}
+	for _, provider := range summary {
+		status[provider.ID] = provider.Status
+	}
+	result := map[string]error{}
+	for _, id := range providers {
+		switch s, ok := status[id]; {
+		case !ok:
+			result[id] = fmt.Errorf("[CheckZoneAuthProviders] Provider %s is not in the provider summary", id)
+		case s != AuthProviderOnline:
+			result[id] = fmt.Errorf("[CheckZoneAuthProviders] Provider %s is %s", id, s)
+		default:
+			result[id] = nil
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,378 @@
+package papilite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Permission levels of a privilege granted to a role
+const (
+	PrivilegeNone    string = "-"
+	PrivilegeRead    string = "r"
+	PrivilegeExecute string = "x"
+	PrivilegeWrite   string = "w"
+)
+
+// rolePermissionVersion is the first platform API version that uses permission levels instead of the read_only flag
+const rolePermissionVersion int = 14
+
+// Level returns the permission level of the privilege. For clusters that only report ReadOnly, PrivilegeRead or
+// PrivilegeWrite is returned
+func (p OnefsPrivilege) Level() string {
+	if p.Permission != "" {
+		return p.Permission
+	}
+	if p.ReadOnly {
+		return PrivilegeRead
+	}
+	return PrivilegeWrite
+}
+
+// GetPrivilegeList returns all the privileges that can be granted to a role in a given access zone
+func (conn *OnefsConn) GetPrivilegeList(zone string) ([]OnefsPrivilege, error) {
+	return conn.GetPrivilegeListContext(context.Background(), zone)
+}
+
+// GetPrivilegeListContext is the same as GetPrivilegeList but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetPrivilegeListContext(ctx context.Context, zone string) ([]OnefsPrivilege, error) {
+	var result struct {
+		Privileges []OnefsPrivilege `json:"privileges"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/privileges",
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[GetPrivilegeList] Response", "json", debugJSON(result))
+	return result.Privileges, nil
+}
+
+// GetRoleList returns a list of OnefsRoles in a given access zone
+func (conn *OnefsConn) GetRoleList(zone string) ([]OnefsRole, error) {
+	return conn.GetRoleListContext(context.Background(), zone)
+}
+
+// GetRoleListContext is the same as GetRoleList but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetRoleListContext(ctx context.Context, zone string) ([]OnefsRole, error) {
+	var result struct {
+		Roles []OnefsRole `json:"roles"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/roles",
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[GetRoleList] Response", "json", debugJSON(result))
+	return result.Roles, nil
+}
+
+// GetRole returns the OnefsRole structure for a specific role including its members and privileges
+func (conn *OnefsConn) GetRole(name string, zone string) (*OnefsRole, error) {
+	return conn.GetRoleContext(context.Background(), name, zone)
+}
+
+// GetRoleContext is the same as GetRole but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetRoleContext(ctx context.Context, name string, zone string) (*OnefsRole, error) {
+	var result struct {
+		Roles []OnefsRole `json:"roles"`
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		conn.PlatformPath+"/auth/roles/"+name,
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[GetRole] Response", "json", debugJSON(result))
+	if len(result.Roles) < 1 {
+		return nil, &emptyListError{"[GetRole] Role list was empty. Expected at least 1 role"}
+	}
+	return &result.Roles[0], nil
+}
+
+// CreateRole creates a new custom role without members or privileges in a given access zone
+// zone: Access zone for the request. Defaults to "System" if the string is empty
+func (conn *OnefsConn) CreateRole(name string, description string, zone string) (map[string]interface{}, error) {
+	return conn.CreateRoleContext(context.Background(), name, description, zone)
+}
+
+// CreateRoleContext is the same as CreateRole but the request is bound to the passed in context.Context
+func (conn *OnefsConn) CreateRoleContext(ctx context.Context, name string, description string, zone string) (map[string]interface{}, error) {
+	body := OnefsRole{
+		Name:        name,
+		Description: description,
+	}
+	if zone == "" {
+		zone = "System"
+	}
+	var result map[string]interface{}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"POST",
+		conn.PlatformPath+"/auth/roles",
+		map[string]string{"zone": zone},
+		body, // body
+		nil,  // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteRole will delete a role
+func (conn *OnefsConn) DeleteRole(name string, zone string) (map[string]interface{}, error) {
+	return conn.DeleteRoleContext(context.Background(), name, zone)
+}
+
+// DeleteRoleContext is the same as DeleteRole but the request is bound to the passed in context.Context
+func (conn *OnefsConn) DeleteRoleContext(ctx context.Context, name string, zone string) (map[string]interface{}, error) {
+	var jsonObj map[string]interface{}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"DELETE",
+		conn.PlatformPath+"/auth/roles/"+name,
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&jsonObj,
+	)
+	if err != nil {
+		conn.Logger().Error("[DeleteRole] Error", "role", name, "zone", zone, "error", err)
+		return nil, err
+	}
+	return jsonObj, nil
+}
+
+// GrantRolePrivilege grants a privilege, e.g. ISI_PRIV_SMB, to a role with the given permission level. If the role
+// already has the privilege its permission level is changed
+// permission: One of PrivilegeRead, PrivilegeExecute, PrivilegeWrite or PrivilegeNone. Clusters with a platform API
+// version before 14, or with an unknown version, only support the read_only flag. On those clusters PrivilegeNone
+// revokes the privilege and PrivilegeExecute returns an UnsupportedError
+// Only the given privilege is sent so privileges granted to the same role by other clients at the same time are kept.
+// Changing the permission level of a privilege that is already granted removes the privilege and grants it again, so
+// the role does not have the privilege for a short time
+func (conn *OnefsConn) GrantRolePrivilege(role string, privilege string, permission string, zone string) error {
+	return conn.GrantRolePrivilegeContext(context.Background(), role, privilege, permission, zone)
+}
+
+// GrantRolePrivilegeContext is the same as GrantRolePrivilege but the requests are bound to the passed in context.Context
+func (conn *OnefsConn) GrantRolePrivilegeContext(ctx context.Context, role string, privilege string, permission string, zone string) error {
+	switch permission {
+	case PrivilegeNone, PrivilegeRead, PrivilegeExecute, PrivilegeWrite:
+	default:
+		return fmt.Errorf("[GrantRolePrivilege] Invalid permission level: %s", permission)
+	}
+	readOnlyFlag := !conn.SupportsAPIVersion(rolePermissionVersion)
+	if readOnlyFlag {
+		switch permission {
+		case PrivilegeNone:
+			// The read_only flag cannot express no access so the privilege is removed instead
+			return conn.RevokeRolePrivilegeContext(ctx, role, privilege, zone)
+		case PrivilegeExecute:
+			return &UnsupportedError{
+				Feature:    "Execute permission for role privileges",
+				MinVersion: rolePermissionVersion,
+				APIVersion: conn.APIVersion,
+				Release:    conn.Release,
+			}
+		}
+	}
+	body := OnefsPrivilege{ID: privilege, Permission: permission}
+	if readOnlyFlag {
+		body = OnefsPrivilege{ID: privilege, ReadOnly: permission == PrivilegeRead}
+	}
+	conn.Logger().Debug("[GrantRolePrivilege] Request", "body", debugJSON(body))
+	err := conn.addRolePrivilege(ctx, role, body, zone)
+	if IsConflict(err) {
+		// The role already has the privilege. The permission level of a granted privilege cannot be changed on its own
+		// so the privilege is removed and granted again with the new level
+		err = conn.Papi.SendIntoContext(
+			ctx,
+			"DELETE",
+			conn.PlatformPath+"/auth/roles/"+role+"/privileges/"+privilege,
+			map[string]string{"zone": zone},
+			nil, // body
+			nil, // extra headers
+			&struct{}{},
+		)
+		if err == nil || IsNotFound(err) {
+			err = conn.addRolePrivilege(ctx, role, body, zone)
+		}
+	}
+	if err != nil {
+		conn.Logger().Error("[GrantRolePrivilege] Error", "role", role, "privilege", privilege, "zone", zone, "error", err)
+		return err
+	}
+	return nil
+}
+
+// addRolePrivilege adds a single privilege to a role. A conflict error is returned if the role already has the privilege
+func (conn *OnefsConn) addRolePrivilege(ctx context.Context, role string, privilege OnefsPrivilege, zone string) error {
+	return conn.Papi.SendIntoContext(
+		ctx,
+		"POST",
+		conn.PlatformPath+"/auth/roles/"+role+"/privileges",
+		map[string]string{"zone": zone},
+		privilege, // body
+		nil,       // extra headers
+		&struct{}{},
+	)
+}
+
+// RevokeRolePrivilege removes a privilege from a role
+// If the role does not have the privilege the not found error returned by the API is ignored and no error is returned.
+// A not found error is still returned if the role does not exist
+func (conn *OnefsConn) RevokeRolePrivilege(role string, privilege string, zone string) error {
+	return conn.RevokeRolePrivilegeContext(context.Background(), role, privilege, zone)
+}
+
+// RevokeRolePrivilegeContext is the same as RevokeRolePrivilege but the requests are bound to the passed in context.Context
+func (conn *OnefsConn) RevokeRolePrivilegeContext(ctx context.Context, role string, privilege string, zone string) error {
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"DELETE",
+		conn.PlatformPath+"/auth/roles/"+role+"/privileges/"+privilege,
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&struct{}{},
+	)
+	if err != nil {
+		// The role not having the privilege is the desired end state so the error can be ignored. The same error is
+		// returned for a missing role so the role is checked before the error is ignored
+		if IsNotFound(err) {
+			if _, roleErr := conn.GetRoleContext(ctx, role, zone); roleErr == nil {
+				return nil
+			}
+		}
+		conn.Logger().Error("[RevokeRolePrivilege] Error", "role", role, "privilege", privilege, "zone", zone, "error", err)
+		return err
+	}
+	return nil
+}
+
+// AddRoleMember adds a user or group to a role. The member is identified by Name and Type, e.g. "user" or "group", or
+// by ID, e.g. UID:2000
+// If the persona is already a member of the role the conflict returned by the API is ignored and no error is returned
+func (conn *OnefsConn) AddRoleMember(role string, member OnefsID, zone string) (map[string]interface{}, error) {
+	return conn.AddRoleMemberContext(context.Background(), role, member, zone)
+}
+
+// AddRoleMemberContext is the same as AddRoleMember but the request is bound to the passed in context.Context
+func (conn *OnefsConn) AddRoleMemberContext(ctx context.Context, role string, member OnefsID, zone string) (map[string]interface{}, error) {
+	conn.Logger().Debug("[AddRoleMember] Request", "body", debugJSON(member))
+	var jsonObj map[string]interface{}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"POST",
+		conn.PlatformPath+"/auth/roles/"+role+"/members",
+		map[string]string{"zone": zone},
+		member, // body
+		nil,    // extra headers
+		&jsonObj,
+	)
+	if err != nil {
+		if !IsConflict(err) {
+			conn.Logger().Error("[AddRoleMember] Request error", "role", role, "member", member, "zone", zone, "error", err)
+			return nil, err
+		}
+		return nil, nil
+	}
+	conn.Logger().Debug("[AddRoleMember] Response", "json", debugJSON(jsonObj))
+	return jsonObj, nil
+}
+
+// RemoveRoleMember removes a user or group from a role. This is the inverse of AddRoleMember
+// If the persona is not a member of the role the not found error returned by the API is ignored and no error is returned.
+// A not found error is still returned if the role does not exist
+func (conn *OnefsConn) RemoveRoleMember(role string, member OnefsID, zone string) error {
+	return conn.RemoveRoleMemberContext(context.Background(), role, member, zone)
+}
+
+// RemoveRoleMemberContext is the same as RemoveRoleMember but the requests are bound to the passed in context.Context
+func (conn *OnefsConn) RemoveRoleMemberContext(ctx context.Context, role string, member OnefsID, zone string) error {
+	persona := member.ID
+	if persona == "" {
+		persona = strings.ToUpper(member.Type) + ":" + member.Name
+	}
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"DELETE",
+		conn.PlatformPath+"/auth/roles/"+role+"/members/"+persona,
+		map[string]string{"zone": zone},
+		nil, // body
+		nil, // extra headers
+		&struct{}{},
+	)
+	if err != nil {
+		// The persona not being a member of the role is the desired end state so the error can be ignored. The same
+		// error is returned for a missing role so the role is checked before the error is ignored
+		if IsNotFound(err) {
+			if _, roleErr := conn.GetRoleContext(ctx, role, zone); roleErr == nil {
+				return nil
+			}
+		}
+		conn.Logger().Error("[RemoveRoleMember] Error", "role", role, "member", persona, "zone", zone, "error", err)
+		return err
+	}
+	return nil
+}
+
+// GetEffectivePrivileges returns the privileges a user has in an access zone through all of its roles, including the
+// roles of the groups the user is a member of. If user is empty the privileges of the user of the connection are
+// returned and zone is ignored
+func (conn *OnefsConn) GetEffectivePrivileges(user string, zone string) ([]OnefsPrivilege, error) {
+	return conn.GetEffectivePrivilegesContext(context.Background(), user, zone)
+}
+
+// GetEffectivePrivilegesContext is the same as GetEffectivePrivileges but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetEffectivePrivilegesContext(ctx context.Context, user string, zone string) ([]OnefsPrivilege, error) {
+	if user == "" {
+		var result struct {
+			Ntoken struct {
+				Privilege []OnefsPrivilege `json:"privilege"`
+			} `json:"ntoken"`
+		}
+		err := conn.Papi.SendIntoContext(
+			ctx,
+			"GET",
+			conn.PlatformPath+"/auth/id",
+			nil, // query args
+			nil, // body
+			nil, // extra headers
+			&result,
+		)
+		if err != nil {
+			return nil, err
+		}
+		conn.Logger().Debug("[GetEffectivePrivileges] Response", "json", debugJSON(result))
+		return result.Ntoken.Privilege, nil
+	}
+	token, err := conn.LookupUserMappingContext(ctx, user, zone)
+	if err != nil {
+		return nil, err
+	}
+	return token.Privileges, nil
+}
@@ -0,0 +1,217 @@
+package papilite
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultPoolMaxParallel int = 4
+
+// ClusterPool manages connections to multiple OneFS clusters identified by a name. Connections are created on first
+// use with NewPapiConn and Connect and are reused for later calls. All methods are safe for concurrent use.
+//
+//	pool := NewClusterPool()
+//	pool.Add("prod", &OnefsCfg{User: "api_user", Password: "pw", Endpoint: "https://prod.fqdn:8080"})
+//	pool.Add("dr", &OnefsCfg{User: "api_user", Password: "pw", Endpoint: "https://dr.fqdn:8080"})
+//	defer pool.Close()
+//	results := pool.ForEach(func(ctx context.Context, name string, conn *OnefsConn) (interface{}, error) {
+//		return conn.GetUserListContext(ctx, "System")
+//	})
+//	for _, result := range results {
+//		if result.Err != nil {
+//			fmt.Printf("%s: %s\n", result.Name, result.Err)
+//		}
+//	}
+type ClusterPool struct {
+	// MaxParallel limits how many clusters ForEach calls at the same time. Defaults to 4 when set to 0 or less
+	MaxParallel int
+	mu          sync.Mutex
+	clusters    map[string]*poolEntry
+}
+
+// poolEntry is a single cluster in a ClusterPool
+type poolEntry struct {
+	cfg  *OnefsCfg
+	mu   sync.Mutex
+	conn *OnefsConn
+}
+
+// ClusterFunc is a function that ForEach calls for every cluster in a ClusterPool
+type ClusterFunc func(ctx context.Context, name string, conn *OnefsConn) (interface{}, error)
+
+// ClusterResult is the result of a ClusterFunc for a single cluster. Err is set when the connection or the call failed
+type ClusterResult struct {
+	Name  string
+	Value interface{}
+	Err   error
+}
+
+// ClusterResults are the results of a ForEach call sorted by cluster name
+type ClusterResults []ClusterResult
+
+// Err returns an error listing every cluster that failed or nil if all calls succeeded
+func (results ClusterResults) Err() error {
+	var failed []string
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.Name, result.Err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("[ClusterPool] %d of %d clusters failed: %s", len(failed), len(results), strings.Join(failed, "; "))
+}
+
+// NewClusterPool returns an empty ClusterPool
+func NewClusterPool() *ClusterPool {
+	return &ClusterPool{
+		MaxParallel: defaultPoolMaxParallel,
+		clusters:    map[string]*poolEntry{},
+	}
+}
+
+// Add adds a cluster configuration to the pool. An existing cluster with the same name is disconnected and replaced
+func (pool *ClusterPool) Add(name string, cfg *OnefsCfg) {
+	pool.mu.Lock()
+	old := pool.clusters[name]
+	pool.clusters[name] = &poolEntry{cfg: cfg}
+	pool.mu.Unlock()
+	if old != nil {
+		old.disconnect(context.Background())
+	}
+}
+
+// Remove disconnects a cluster and removes it from the pool
+func (pool *ClusterPool) Remove(name string) error {
+	pool.mu.Lock()
+	entry, ok := pool.clusters[name]
+	delete(pool.clusters, name)
+	pool.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("[ClusterPool] Unknown cluster: %s", name)
+	}
+	return entry.disconnect(context.Background())
+}
+
+// Names returns the sorted names of all clusters in the pool
+func (pool *ClusterPool) Names() []string {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	names := make([]string, 0, len(pool.clusters))
+	for name := range pool.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Conn returns the connection to a cluster. The connection is created on the first call for a cluster and when an
+// earlier connection attempt failed
+func (pool *ClusterPool) Conn(name string) (*OnefsConn, error) {
+	return pool.ConnContext(context.Background(), name)
+}
+
+// ConnContext is the same as Conn but the connection requests are bound to the passed in context.Context
+func (pool *ClusterPool) ConnContext(ctx context.Context, name string) (*OnefsConn, error) {
+	pool.mu.Lock()
+	entry, ok := pool.clusters[name]
+	pool.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("[ClusterPool] Unknown cluster: %s", name)
+	}
+	return entry.connect(ctx)
+}
+
+// ForEach calls fn for every cluster in the pool with at most MaxParallel calls running at the same time. The results
+// are sorted by cluster name and contain the error for every cluster that could not be connected or whose call failed
+func (pool *ClusterPool) ForEach(fn ClusterFunc) ClusterResults {
+	return pool.ForEachContext(context.Background(), fn)
+}
+
+// ForEachContext is the same as ForEach but the connections and calls are bound to the passed in context.Context.
+// Clusters that have not started when the context is cancelled are not called and return the context error
+func (pool *ClusterPool) ForEachContext(ctx context.Context, fn ClusterFunc) ClusterResults {
+	names := pool.Names()
+	maxParallel := pool.MaxParallel
+	if maxParallel <= 0 {
+		maxParallel = defaultPoolMaxParallel
+	}
+	results := make(ClusterResults, len(names))
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		results[i].Name = name
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(result *ClusterResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			conn, err := pool.ConnContext(ctx, result.Name)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			result.Value, result.Err = fn(ctx, result.Name, conn)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// Close disconnects all clusters in the pool. The configurations are kept so the pool can be used again
+func (pool *ClusterPool) Close() error {
+	return pool.CloseContext(context.Background())
+}
+
+// CloseContext is the same as Close but the disconnect requests are bound to the passed in context.Context
+func (pool *ClusterPool) CloseContext(ctx context.Context) error {
+	pool.mu.Lock()
+	entries := make([]*poolEntry, 0, len(pool.clusters))
+	for _, entry := range pool.clusters {
+		entries = append(entries, entry)
+	}
+	pool.mu.Unlock()
+	var firstErr error
+	for _, entry := range entries {
+		if err := entry.disconnect(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// connect returns the existing connection of an entry or creates a new one
+func (entry *poolEntry) connect(ctx context.Context) (*OnefsConn, error) {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.conn != nil {
+		return entry.conn, nil
+	}
+	conn := NewPapiConn()
+	if err := conn.ConnectContext(ctx, entry.cfg); err != nil {
+		return nil, err
+	}
+	entry.conn = conn
+	return conn, nil
+}
+
+// disconnect closes the connection of an entry if one exists
+func (entry *poolEntry) disconnect(ctx context.Context) error {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.conn == nil {
+		return nil
+	}
+	err := entry.conn.DisconnectContext(ctx)
+	entry.conn = nil
+	return err
+}
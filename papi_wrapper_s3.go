@@ -1,8 +1,7 @@
 package papilite
 
 import (
-	"encoding/json"
-	"github.com/mitchellh/mapstructure"
+	"context"
 )
 
 // GetS3Token creates a new S3 access secret. Returns a structure containing the current and former access keys and secrets.
@@ -11,38 +10,41 @@ import (
 // zone: Access zone for the request. Defaults to "System" if the string is empty
 // ttl: Time in minutes to expire the old key. Defaults to no expiration if ttl is set to 0
 func (conn *OnefsConn) GetS3Token(name string, zone string, ttl int) (*OnefsS3Key, error) {
-	var bodyJSON []byte
-	var err error
+	return conn.GetS3TokenContext(context.Background(), name, zone, ttl)
+}
+
+// GetS3TokenContext is the same as GetS3Token but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetS3TokenContext(ctx context.Context, name string, zone string, ttl int) (*OnefsS3Key, error) {
+	var body interface{}
 	if ttl > 0 {
-		body := struct {
+		body = struct {
 			TTL int `json:"existing_key_expiry_time"`
 		}{TTL: ttl}
-		bodyJSON, err = json.Marshal(body)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		bodyJSON = nil
 	}
 	if zone == "" {
 		zone = "System"
 	}
-	//conn.Logger().Debug(fmt.Sprintf("[GetS3Token] S3 token body request: %s", bodyJSON))
-	jsonObj, err := conn.Papi.Send(
-		"POST",
-		conn.PlatformPath+"/protocols/s3/keys/"+name,
-		map[string]string{"force": "true", "zone": zone},
-		bodyJSON, // body
-		nil,      // extra headers
-	)
+	// S3 was added in OneFS 9.0 with platform API version 10
+	platformPath, err := conn.PlatformPathFor("S3 keys", 10, 0)
 	if err != nil {
 		return nil, err
 	}
-	//conn.Logger().Debug(fmt.Sprintf("[GetS3Token] JSON: %s", debug_json(jsonObj)))
-	var result struct{ Keys OnefsS3Key }
-	err = mapstructure.Decode(jsonObj, &result)
+	conn.Logger().Debug("[GetS3Token] S3 token body request", "body", debugJSON(body))
+	var result struct {
+		Keys OnefsS3Key `json:"keys"`
+	}
+	err = conn.Papi.SendIntoContext(
+		ctx,
+		"POST",
+		platformPath+"/protocols/s3/keys/"+name,
+		map[string]string{"force": "true", "zone": zone},
+		body, // body
+		nil,  // extra headers
+		&result,
+	)
 	if err != nil {
 		return nil, err
 	}
-	return &result.Keys, err
+	conn.Logger().Debug("[GetS3Token] Response", "json", debugJSON(result))
+	return &result.Keys, nil
 }
@@ -1,8 +1,17 @@
 package papilite
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/murkyl/go-papi-lite/papitest"
 )
 
 // TestListAllUsers gets all access zones and then lists all the users in each access zone
@@ -32,3 +41,811 @@ func TestListAllUsers(t *testing.T) {
 	}
 	conn.Disconnect()
 }
+
+// newFakeConn starts a fake PAPI server and returns a connection to it
+func newFakeConn(t *testing.T) (*OnefsConn, *papitest.Server) {
+	srv := papitest.NewServer()
+	conn := NewPapiConn()
+	err := conn.Connect(&OnefsCfg{
+		User:     papitest.DefaultUser,
+		Password: papitest.DefaultPassword,
+		Endpoint: srv.URL,
+	})
+	if err != nil {
+		srv.Close()
+		t.Fatalf("Unable to connect to fake server: %s", err)
+	}
+	return conn, srv
+}
+
+func TestFakeUserLifecycle(t *testing.T) {
+	conn, srv := newFakeConn(t)
+	defer srv.Close()
+	defer conn.Disconnect()
+	srv.SetPageSize(2)
+	srv.AddGroup("System", papitest.Group{Name: "group1"})
+	for i := 0; i < 5; i++ {
+		if _, err := conn.CreateUser(fmt.Sprintf("user%d", i), "/ifs/home", "group1", "System"); err != nil {
+			t.Fatalf("CreateUser failed: %s", err)
+		}
+	}
+	if _, err := conn.CreateUser("user0", "/ifs/home", "group1", "System"); !IsConflict(err) {
+		t.Errorf("Expected a conflict error creating a duplicate user, got: %v", err)
+	}
+	users, err := conn.GetUserList("System")
+	if err != nil || len(users) != 5 {
+		t.Fatalf("Expected 5 users from a paginated list, got %d with error: %v", len(users), err)
+	}
+	it := conn.GetUserIterator("System")
+	count := 0
+	for it.Next() {
+		count++
+	}
+	if it.Err() != nil || count != 5 {
+		t.Errorf("Expected the iterator to return 5 users, got %d with error: %v", count, it.Err())
+	}
+	if err := conn.SetUserSuplementalGroups("user1", []string{"group1"}, "System"); err != nil {
+		t.Errorf("SetUserSuplementalGroups failed: %s", err)
+	}
+	// Adding a user to a group twice must not fail
+	if _, err := conn.AddUserToGroup("user1", "group1", "System"); err != nil {
+		t.Errorf("AddUserToGroup for an existing member failed: %s", err)
+	}
+	user, err := conn.GetUser("user1", "System")
+	if err != nil || len(user.MemberOf) != 1 || user.MemberOf[0].Name != "group1" {
+		t.Errorf("Expected user1 to be a member of group1, got %+v with error: %v", user, err)
+	}
+	key, err := conn.GetS3Token("user1", "System", 0)
+	if err != nil || key.AccessID == "" || key.SecretKey == "" {
+		t.Errorf("Expected an S3 key, got %+v with error: %v", key, err)
+	}
+	if _, err := conn.DeleteUser("user1", "System"); err != nil {
+		t.Errorf("DeleteUser failed: %s", err)
+	}
+	if _, err := conn.GetUser("user1", "System"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a deleted user, got: %v", err)
+	}
+}
+
+func TestFakeUserModify(t *testing.T) {
+	conn, srv := newFakeConn(t)
+	defer srv.Close()
+	defer conn.Disconnect()
+	srv.AddGroup("System", papitest.Group{Name: "group1"})
+	disabled := false
+	_, err := conn.CreateUserWithOptions(OnefsUserOptions{
+		Name:         "user1",
+		Password:     "secret1",
+		Email:        "user1@example.com",
+		PrimaryGroup: "group1",
+		UID:          3000,
+		Enabled:      &disabled,
+	}, "")
+	if err != nil {
+		t.Fatalf("CreateUserWithOptions failed: %s", err)
+	}
+	user, err := conn.GetUser("user1", "System")
+	if err != nil {
+		t.Fatalf("GetUser failed: %s", err)
+	}
+	if user.Enabled || user.UID.ID != "UID:3000" || user.OnDiskUserIdentity.ID != "UID:3000" || user.PrimaryGroup.Name != "group1" || user.SID.ID == "" || user.Provider == "" {
+		t.Errorf("Unexpected user after create: %+v", user)
+	}
+
+	shell := "/bin/zsh"
+	enabled := true
+	uid := 3001
+	if err := conn.ModifyUser("user1", OnefsUserUpdate{Shell: &shell, Enabled: &enabled, UID: &uid}, "System"); err != nil {
+		t.Fatalf("ModifyUser failed: %s", err)
+	}
+	user, err = conn.GetUser("user1", "System")
+	if err != nil || user.Shell != shell || !user.Enabled || user.UID.ID != "UID:3001" || user.Email != "user1@example.com" {
+		t.Errorf("Unexpected user after modify: %+v with error: %v", user, err)
+	}
+
+	if err := conn.ChangeUserPassword("user1", "wrong", "secret2", "System"); err == nil {
+		t.Errorf("Expected an error for an invalid old password")
+	}
+	if err := conn.ChangeUserPassword("user1", "secret1", "secret2", "System"); err != nil {
+		t.Fatalf("ChangeUserPassword failed: %s", err)
+	}
+	if srv.User("System", "user1").Password != "secret2" {
+		t.Errorf("Password was not changed")
+	}
+
+	srv.AddUser("System", papitest.User{Name: "locked", Locked: true})
+	if err := conn.UnlockUser("locked", "System"); err != nil {
+		t.Fatalf("UnlockUser failed: %s", err)
+	}
+	if user, err := conn.GetUser("locked", "System"); err != nil || user.Locked {
+		t.Errorf("Expected the user to be unlocked, got %+v with error: %v", user, err)
+	}
+	if err := conn.ModifyUser("missing", OnefsUserUpdate{Shell: &shell}, "System"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a missing user, got: %v", err)
+	}
+}
+
+func TestFakeGroupLifecycle(t *testing.T) {
+	conn, srv := newFakeConn(t)
+	defer srv.Close()
+	defer conn.Disconnect()
+	srv.AddZone(papitest.Zone{Name: "zone1", Path: "/ifs/zone1"})
+	for _, name := range []string{"parent", "child", "grandchild"} {
+		if _, err := conn.CreateGroup(name, 0, "zone1"); err != nil {
+			t.Fatalf("CreateGroup failed: %s", err)
+		}
+	}
+	if _, err := conn.CreateGroup("parent", 0, "zone1"); !IsConflict(err) {
+		t.Errorf("Expected a conflict for an existing group, got: %v", err)
+	}
+	if _, err := conn.CreateGroup("withgid", 5000, "zone1"); err != nil {
+		t.Fatalf("CreateGroup with GID failed: %s", err)
+	}
+	groups, err := conn.GetGroupList("zone1")
+	if err != nil || len(groups) != 4 {
+		t.Fatalf("Expected 4 groups, got %d with error: %v", len(groups), err)
+	}
+	if _, err := conn.GetGroupList("System"); err != nil {
+		t.Errorf("GetGroupList failed for the System zone: %s", err)
+	}
+	gid := 6000
+	if err := conn.ModifyGroup("withgid", OnefsGroupUpdate{GID: &gid}, "zone1"); err != nil {
+		t.Fatalf("ModifyGroup failed: %s", err)
+	}
+	group, err := conn.GetGroup("withgid", "zone1")
+	if err != nil || group.GID.ID != "GID:6000" {
+		t.Errorf("Expected GID 6000, got %+v with error: %v", group, err)
+	}
+
+	// parent contains child, which contains grandchild and a loop back to parent
+	for i := 0; i < 3; i++ {
+		srv.AddUser("zone1", papitest.User{Name: fmt.Sprintf("user%d", i)})
+	}
+	nesting := [][2]string{{"child", "parent"}, {"grandchild", "child"}, {"parent", "grandchild"}}
+	for _, pair := range nesting {
+		if _, err := conn.AddGroupToGroup(pair[0], pair[1], "zone1"); err != nil {
+			t.Fatalf("AddGroupToGroup failed: %s", err)
+		}
+	}
+	if _, err := conn.AddGroupToGroup("child", "parent", "zone1"); err != nil {
+		t.Errorf("Expected a conflict to be ignored, got: %v", err)
+	}
+	memberships := [][2]string{{"user0", "parent"}, {"user1", "child"}, {"user2", "grandchild"}, {"user0", "grandchild"}}
+	for _, pair := range memberships {
+		if _, err := conn.AddUserToGroup(pair[0], pair[1], "zone1"); err != nil {
+			t.Fatalf("AddUserToGroup failed: %s", err)
+		}
+	}
+	members, err := conn.GetGroupMembers("parent", "zone1")
+	if err != nil || len(members) != 2 {
+		t.Errorf("Expected 2 direct members, got %v with error: %v", members, err)
+	}
+	users, err := conn.GetGroupMembersRecursive("parent", "zone1")
+	if err != nil || len(users) != 3 {
+		t.Errorf("Expected 3 nested users, got %v with error: %v", users, err)
+	}
+	group, err = conn.GetGroup("child", "zone1")
+	if err != nil || len(group.MemberOf) != 1 || group.MemberOf[0].Name != "parent" {
+		t.Errorf("Expected child to be a member of parent, got %+v with error: %v", group, err)
+	}
+
+	if err := conn.RemoveUserFromGroup("user0", "parent", "zone1"); err != nil {
+		t.Fatalf("RemoveUserFromGroup failed: %s", err)
+	}
+	if err := conn.RemoveUserFromGroup("user0", "parent", "zone1"); err != nil {
+		t.Errorf("Expected removing a non member to be ignored, got: %v", err)
+	}
+	if err := conn.RemoveUserFromGroup("user0", "missing", "zone1"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a missing group, got: %v", err)
+	}
+	if err := conn.RemoveGroupFromGroup("child", "missing", "zone1"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a missing parent group, got: %v", err)
+	}
+	if err := conn.RemoveGroupFromGroup("child", "parent", "zone1"); err != nil {
+		t.Fatalf("RemoveGroupFromGroup failed: %s", err)
+	}
+	if members, err := conn.GetGroupMembers("parent", "zone1"); err != nil || len(members) != 0 {
+		t.Errorf("Expected no members, got %v with error: %v", members, err)
+	}
+	if _, err := conn.DeleteGroup("child", "zone1"); err != nil {
+		t.Fatalf("DeleteGroup failed: %s", err)
+	}
+	if _, err := conn.GetGroup("child", "zone1"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a deleted group, got: %v", err)
+	}
+	if srv.Group("zone1", "grandchild") == nil || srv.Group("System", "parent") != nil {
+		t.Errorf("Groups were not created in the expected access zone")
+	}
+}
+
+func TestGroupMembersRecursiveByID(t *testing.T) {
+	// Nested groups from some providers are only returned with an ID
+	session, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		if zone := r.URL.Query().Get("zone"); zone != "System" {
+			t.Errorf("Expected the System zone to be used by default, got %q", zone)
+		}
+		switch r.URL.Path {
+		case "/platform/10/auth/groups/parent/members":
+			fmt.Fprint(w, `{"members": [{"id": "GID:3000", "type": "group"}, {"id": "UID:2000", "name": "user0", "type": "user"}]}`)
+		case "/platform/10/auth/groups/GID:3000/members":
+			fmt.Fprint(w, `{"members": [{"id": "UID:2001", "name": "user1", "type": "user"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer srv.Close()
+	conn := &OnefsConn{Papi: session, PlatformPath: "platform/10"}
+	users, err := conn.GetGroupMembersRecursive("parent", "")
+	if err != nil || len(users) != 2 {
+		t.Errorf("Expected 2 nested users, got %v with error: %v", users, err)
+	}
+}
+
+func TestFakeRoles(t *testing.T) {
+	conn, srv := newFakeConn(t)
+	defer srv.Close()
+	defer conn.Disconnect()
+	srv.AddZone(papitest.Zone{Name: "zone1", Path: "/ifs/zone1"})
+	srv.AddUser("zone1", papitest.User{Name: "user1"})
+	srv.AddGroup("zone1", papitest.Group{Name: "admins"})
+	if _, err := conn.AddUserToGroup("user1", "admins", "zone1"); err != nil {
+		t.Fatalf("AddUserToGroup failed: %s", err)
+	}
+	privileges, err := conn.GetPrivilegeList("zone1")
+	if err != nil || len(privileges) == 0 {
+		t.Fatalf("Expected a list of privileges, got %v with error: %v", privileges, err)
+	}
+	for _, name := range []string{"smbadmin", "auditor"} {
+		if _, err := conn.CreateRole(name, "Onboarding role", "zone1"); err != nil {
+			t.Fatalf("CreateRole failed: %s", err)
+		}
+	}
+	if _, err := conn.CreateRole("smbadmin", "", "zone1"); !IsConflict(err) {
+		t.Errorf("Expected a conflict for an existing role, got: %v", err)
+	}
+	grants := []struct {
+		role       string
+		privilege  string
+		permission string
+	}{
+		{"smbadmin", "ISI_PRIV_SMB", PrivilegeRead},
+		{"smbadmin", "ISI_PRIV_LOGIN_PAPI", PrivilegeRead},
+		{"smbadmin", "ISI_PRIV_SMB", PrivilegeWrite},
+		{"auditor", "ISI_PRIV_SMB", PrivilegeRead},
+		{"auditor", "ISI_PRIV_QUOTA", PrivilegeRead},
+	}
+	for _, grant := range grants {
+		if err := conn.GrantRolePrivilege(grant.role, grant.privilege, grant.permission, "zone1"); err != nil {
+			t.Fatalf("GrantRolePrivilege failed: %s", err)
+		}
+	}
+	role, err := conn.GetRole("smbadmin", "zone1")
+	if err != nil || len(role.Privileges) != 2 {
+		t.Fatalf("Expected 2 privileges, got %+v with error: %v", role, err)
+	}
+	for _, privilege := range role.Privileges {
+		if privilege.ID == "ISI_PRIV_SMB" && privilege.Level() != PrivilegeWrite {
+			t.Errorf("Expected the SMB privilege to be upgraded to write, got %s", privilege.Level())
+		}
+	}
+
+	// Clusters that only support the read_only flag cannot grant execute access and remove a privilege without access
+	if err := conn.GrantRolePrivilege("smbadmin", "ISI_PRIV_SMB", PrivilegeExecute, "zone1"); !IsUnsupported(err) {
+		t.Errorf("Expected an unsupported error for execute permission, got: %v", err)
+	}
+	if err := conn.GrantRolePrivilege("smbadmin", "ISI_PRIV_SMB", "rw", "zone1"); err == nil {
+		t.Errorf("Expected an error for an invalid permission level")
+	}
+	if err := conn.GrantRolePrivilege("auditor", "ISI_PRIV_LOGIN_PAPI", PrivilegeRead, "zone1"); err != nil {
+		t.Fatalf("GrantRolePrivilege failed: %s", err)
+	}
+	if err := conn.GrantRolePrivilege("auditor", "ISI_PRIV_LOGIN_PAPI", PrivilegeNone, "zone1"); err != nil {
+		t.Fatalf("GrantRolePrivilege failed: %s", err)
+	}
+	if role, err := conn.GetRole("auditor", "zone1"); err != nil || len(role.Privileges) != 2 {
+		t.Errorf("Expected no access to remove the privilege, got %+v with error: %v", role, err)
+	}
+	// An unknown cluster version is treated like a cluster that only supports the read_only flag
+	conn.APIVersion = 0
+	if err := conn.GrantRolePrivilege("smbadmin", "ISI_PRIV_SMB", PrivilegeExecute, "zone1"); !IsUnsupported(err) {
+		t.Errorf("Expected an unsupported error for an unknown cluster version, got: %v", err)
+	}
+	conn.APIVersion = 12
+
+	// user1 is a direct member of auditor and a member of smbadmin through the admins group
+	if _, err := conn.AddRoleMember("auditor", OnefsID{Name: "user1", Type: "user"}, "zone1"); err != nil {
+		t.Fatalf("AddRoleMember failed: %s", err)
+	}
+	if _, err := conn.AddRoleMember("smbadmin", OnefsID{Name: "admins", Type: "group"}, "zone1"); err != nil {
+		t.Fatalf("AddRoleMember failed: %s", err)
+	}
+	if _, err := conn.AddRoleMember("smbadmin", OnefsID{Name: "admins", Type: "group"}, "zone1"); err != nil {
+		t.Errorf("Expected a conflict to be ignored, got: %v", err)
+	}
+	effective, err := conn.GetEffectivePrivileges("user1", "zone1")
+	if err != nil {
+		t.Fatalf("GetEffectivePrivileges failed: %s", err)
+	}
+	levels := map[string]string{}
+	for _, privilege := range effective {
+		levels[privilege.ID] = privilege.Level()
+	}
+	want := map[string]string{"ISI_PRIV_SMB": PrivilegeWrite, "ISI_PRIV_LOGIN_PAPI": PrivilegeRead, "ISI_PRIV_QUOTA": PrivilegeRead}
+	if !reflect.DeepEqual(levels, want) {
+		t.Errorf("Expected effective privileges %v, got %v", want, levels)
+	}
+
+	if err := conn.RevokeRolePrivilege("auditor", "ISI_PRIV_QUOTA", "zone1"); err != nil {
+		t.Fatalf("RevokeRolePrivilege failed: %s", err)
+	}
+	if err := conn.RevokeRolePrivilege("auditor", "ISI_PRIV_QUOTA", "zone1"); err != nil {
+		t.Errorf("Expected revoking a missing privilege to be ignored, got: %v", err)
+	}
+	if err := conn.RevokeRolePrivilege("missing", "ISI_PRIV_QUOTA", "zone1"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a missing role, got: %v", err)
+	}
+	if err := conn.RemoveRoleMember("missing", OnefsID{Name: "admins", Type: "group"}, "zone1"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a missing role, got: %v", err)
+	}
+	if err := conn.RemoveRoleMember("smbadmin", OnefsID{Name: "admins", Type: "group"}, "zone1"); err != nil {
+		t.Fatalf("RemoveRoleMember failed: %s", err)
+	}
+	if err := conn.RemoveRoleMember("smbadmin", OnefsID{Name: "admins", Type: "group"}, "zone1"); err != nil {
+		t.Errorf("Expected removing a non member to be ignored, got: %v", err)
+	}
+	effective, err = conn.GetEffectivePrivileges("user1", "zone1")
+	if err != nil || len(effective) != 1 || effective[0].ID != "ISI_PRIV_SMB" || effective[0].Level() != PrivilegeRead {
+		t.Errorf("Expected read access to SMB only, got %+v with error: %v", effective, err)
+	}
+	if _, err := conn.DeleteRole("auditor", "zone1"); err != nil {
+		t.Fatalf("DeleteRole failed: %s", err)
+	}
+	roles, err := conn.GetRoleList("zone1")
+	if err != nil || len(roles) != 1 || roles[0].Name != "smbadmin" {
+		t.Errorf("Expected only the smbadmin role, got %+v with error: %v", roles, err)
+	}
+
+	// Clusters with permission levels get the exact level instead of the read_only flag
+	srv.SetLatestVersion("16")
+	if err := conn.Connect(&OnefsCfg{User: papitest.DefaultUser, Password: papitest.DefaultPassword, Endpoint: srv.URL}); err != nil {
+		t.Fatalf("Unable to reconnect: %s", err)
+	}
+	if err := conn.GrantRolePrivilege("smbadmin", "ISI_PRIV_SMB", PrivilegeExecute, "zone1"); err != nil {
+		t.Fatalf("GrantRolePrivilege failed: %s", err)
+	}
+	if role, err := conn.GetRole("smbadmin", "zone1"); err != nil || len(role.Privileges) != 2 {
+		t.Errorf("Expected 2 privileges, got %+v with error: %v", role, err)
+	}
+	for _, privilege := range srv.Role("zone1", "smbadmin").Privileges {
+		if privilege.ID == "ISI_PRIV_SMB" && privilege.Permission != PrivilegeExecute {
+			t.Errorf("Expected execute permission, got %+v", privilege)
+		}
+	}
+
+	// The privileges of the connected user come from auth/id
+	srv.AddRole("System", papitest.Role{
+		Name:       "SystemAdmin",
+		Members:    []papitest.ID{{Name: papitest.DefaultUser, Type: "user"}},
+		Privileges: []papitest.Privilege{{ID: "ISI_PRIV_AUTH", Permission: PrivilegeWrite}},
+	})
+	effective, err = conn.GetEffectivePrivileges("", "")
+	if err != nil || len(effective) != 1 || effective[0].ID != "ISI_PRIV_AUTH" {
+		t.Errorf("Expected the privileges of the connected user, got %+v with error: %v", effective, err)
+	}
+}
+
+func TestFakeRolePrivilegeConcurrentGrants(t *testing.T) {
+	conn, srv := newFakeConn(t)
+	defer srv.Close()
+	defer conn.Disconnect()
+	if _, err := conn.CreateRole("operators", "", "System"); err != nil {
+		t.Fatalf("CreateRole failed: %s", err)
+	}
+	privileges := []string{"ISI_PRIV_SMB", "ISI_PRIV_NFS", "ISI_PRIV_S3", "ISI_PRIV_QUOTA"}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(privileges))
+	for _, privilege := range privileges {
+		wg.Add(1)
+		go func(privilege string) {
+			defer wg.Done()
+			errs <- conn.GrantRolePrivilege("operators", privilege, PrivilegeRead, "System")
+		}(privilege)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("GrantRolePrivilege failed: %s", err)
+		}
+	}
+	role, err := conn.GetRole("operators", "System")
+	if err != nil || len(role.Privileges) != len(privileges) {
+		t.Errorf("Expected %d privileges after concurrent grants, got %+v with error: %v", len(privileges), role, err)
+	}
+}
+
+func TestFakeAuthProviders(t *testing.T) {
+	conn, srv := newFakeConn(t)
+	defer srv.Close()
+	defer conn.Disconnect()
+	srv.AddZone(papitest.Zone{
+		Name:          "zone1",
+		Path:          "/ifs/zone1",
+		AuthProviders: []string{"lsa-activedirectory-provider:AD.EXAMPLE.COM", "lsa-ldap-provider:ldap1", "lsa-local-provider:zone1"},
+	})
+	if _, err := conn.JoinAdsDomain("AD.EXAMPLE.COM", "administrator", "secret", map[string]interface{}{"hostname": "cluster.ad.example.com"}); err != nil {
+		t.Fatalf("JoinAdsDomain failed: %s", err)
+	}
+	_, err := conn.CreateAuthProvider(AuthProviderLdap, map[string]interface{}{
+		"name":        "ldap1",
+		"base_dn":     "dc=example,dc=com",
+		"server_uris": []string{"ldap://ldap.example.com"},
+		"zone_name":   "zone1",
+	}, "zone1")
+	if err != nil {
+		t.Fatalf("CreateAuthProvider failed: %s", err)
+	}
+	ads, err := conn.GetAdsProvider("AD.EXAMPLE.COM")
+	if err != nil || ads.Status != AuthProviderOnline || ads.Hostname != "cluster.ad.example.com" {
+		t.Errorf("Unexpected AD provider %+v with error: %v", ads, err)
+	}
+	if provider := srv.Provider(AuthProviderAds, "AD.EXAMPLE.COM"); provider == nil || provider.Settings["password"] != nil {
+		t.Errorf("Expected the join credentials not to be stored, got %+v", provider)
+	}
+	if list, err := conn.GetLdapProviderList("zone1"); err != nil || len(list) != 1 || len(list[0].ServerURIs) != 1 {
+		t.Errorf("Expected 1 LDAP provider, got %+v with error: %v", list, err)
+	}
+	if err := conn.ModifyAuthProvider(AuthProviderLdap, "ldap1", map[string]interface{}{"base_dn": "dc=corp,dc=example,dc=com"}, "zone1"); err != nil {
+		t.Fatalf("ModifyAuthProvider failed: %s", err)
+	}
+	if ldap, err := conn.GetLdapProvider("ldap1", "zone1"); err != nil || ldap.BaseDN != "dc=corp,dc=example,dc=com" {
+		t.Errorf("Expected the base DN to be changed, got %+v with error: %v", ldap, err)
+	}
+	if _, err := conn.GetLdapProvider("ldap1", "System"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a provider of another access zone, got: %v", err)
+	}
+	if err := conn.ModifyAuthProvider(AuthProviderLdap, "ldap1", map[string]interface{}{"base_dn": "dc=other"}, "System"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error when modifying a provider from another access zone, got: %v", err)
+	}
+	if local, err := conn.GetLocalProviderList("zone1"); err != nil || len(local) != 1 || local[0].Name != "zone1" {
+		t.Errorf("Expected the local provider of zone1, got %+v with error: %v", local, err)
+	}
+	if files, err := conn.GetFileProviderList(""); err != nil || len(files) != 1 {
+		t.Errorf("Expected the System file provider, got %+v with error: %v", files, err)
+	}
+	if nis, err := conn.GetNisProviderList(""); err != nil || len(nis) != 0 {
+		t.Errorf("Expected no NIS providers, got %+v with error: %v", nis, err)
+	}
+	if _, err := conn.GetNisProvider("missing", ""); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a missing provider, got: %v", err)
+	}
+	spns, err := conn.CheckAdsSPNs("AD.EXAMPLE.COM")
+	if err != nil || len(spns.Missing) != 0 || len(spns.Registered) != 2 {
+		t.Errorf("Expected all SPNs to be registered, got %+v with error: %v", spns, err)
+	}
+
+	health, err := conn.CheckZoneAuthProviders("zone1")
+	if err != nil || len(health) != 3 {
+		t.Fatalf("Expected the health of 3 providers, got %v with error: %v", health, err)
+	}
+	for id, providerErr := range health {
+		if providerErr != nil {
+			t.Errorf("Expected provider %s to be online, got: %v", id, providerErr)
+		}
+	}
+	srv.SetProviderStatus(AuthProviderLdap, "ldap1", "offline")
+	health, err = conn.CheckZoneAuthProviders("zone1")
+	if err != nil || health["lsa-ldap-provider:ldap1"] == nil || health["lsa-local-provider:zone1"] != nil {
+		t.Errorf("Expected only the LDAP provider to be offline, got %v with error: %v", health, err)
+	}
+	if err := conn.LeaveAdsDomain("AD.EXAMPLE.COM"); err != nil {
+		t.Fatalf("LeaveAdsDomain failed: %s", err)
+	}
+	health, err = conn.CheckZoneAuthProviders("zone1")
+	if err != nil || health["lsa-activedirectory-provider:AD.EXAMPLE.COM"] == nil {
+		t.Errorf("Expected the removed AD provider to be reported, got %v with error: %v", health, err)
+	}
+	if _, err := conn.CheckZoneAuthProviders("missing"); err == nil {
+		t.Errorf("Expected an error for a missing access zone")
+	}
+	if err := conn.DeleteAuthProvider(AuthProviderLdap, "ldap1", "zone1"); err != nil {
+		t.Fatalf("DeleteAuthProvider failed: %s", err)
+	}
+	if srv.Provider(AuthProviderLdap, "ldap1") != nil {
+		t.Errorf("Expected the LDAP provider to be deleted")
+	}
+}
+
+func TestFakeIdentityMapping(t *testing.T) {
+	conn, srv := newFakeConn(t)
+	defer srv.Close()
+	defer conn.Disconnect()
+	srv.AddZone(papitest.Zone{Name: "zone1", Path: "/ifs/zone1"})
+	srv.AddUser("zone1", papitest.User{Name: "user1"})
+	srv.AddGroup("zone1", papitest.Group{Name: "group1", Members: []papitest.ID{{Name: "user1", Type: "user"}}})
+	srv.AddRole("zone1", papitest.Role{
+		Name:       "auditors",
+		Members:    []papitest.ID{{Name: "group1", Type: "group"}},
+		Privileges: []papitest.Privilege{{ID: "ISI_PRIV_AUDIT", Permission: PrivilegeRead}},
+	})
+	user := srv.User("zone1", "user1")
+	group := srv.Group("zone1", "group1")
+
+	token, err := conn.LookupUserMapping("user1", "zone1")
+	if err != nil {
+		t.Fatalf("LookupUserMapping failed: %s", err)
+	}
+	if token.User.UID.ID != user.UID.ID || token.User.SID.ID != user.SID.ID || token.Zone != "zone1" {
+		t.Errorf("Unexpected user in access token: %+v", token)
+	}
+	if len(token.Groups) != 2 || token.Groups[1].GID.ID != group.GID.ID || token.Groups[1].SID.ID != group.SID.ID {
+		t.Errorf("Expected the primary group and group1 in the access token, got %+v", token.Groups)
+	}
+	if len(token.Privileges) != 1 || token.Privileges[0].ID != "ISI_PRIV_AUDIT" {
+		t.Errorf("Expected the privileges of the nested role membership, got %+v", token.Privileges)
+	}
+	if _, err := conn.LookupUserMapping("missing", "zone1"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a missing user, got: %v", err)
+	}
+
+	// Persona resolution works from any identity of a user or group
+	for _, persona := range []string{"user1", "USER:user1", user.UID.ID, user.SID.ID} {
+		resolved, err := conn.ResolvePersona(persona, "zone1")
+		if err != nil || resolved.Type != "user" || resolved.Name != "user1" || resolved.UID.ID != user.UID.ID || resolved.SID.ID != user.SID.ID {
+			t.Errorf("Unexpected persona for %s: %+v with error: %v", persona, resolved, err)
+		}
+	}
+	for _, persona := range []string{"group1", "GROUP:group1", group.GID.ID, group.SID.ID} {
+		resolved, err := conn.ResolvePersona(persona, "zone1")
+		if err != nil || resolved.Type != "group" || resolved.Name != "group1" || resolved.GID.ID != group.GID.ID || resolved.SID.ID != group.SID.ID {
+			t.Errorf("Unexpected persona for %s: %+v with error: %v", persona, resolved, err)
+		}
+	}
+	if _, err := conn.ResolvePersona("UID:99999", "zone1"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for an unknown UID, got: %v", err)
+	}
+	if _, err := conn.ResolvePersona("user1", "System"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a user of another access zone, got: %v", err)
+	}
+	if _, err := conn.ResolvePersona("FOO:bar", "zone1"); err == nil {
+		t.Errorf("Expected an error for an unsupported persona type")
+	}
+
+	// Identity mappings
+	target := "SID:S-1-5-21-9000-9000-9000-1001"
+	if err := conn.CreateIdentityMapping(user.UID.ID, target, true, false, "zone1"); err != nil {
+		t.Fatalf("CreateIdentityMapping failed: %s", err)
+	}
+	mapping, err := conn.GetIdentityMapping(user.UID.ID, "zone1")
+	if err != nil || mapping.Source.Name != "user1" || len(mapping.Targets) != 1 || mapping.Targets[0].Target.ID != target || mapping.Targets[0].Type != "equivalent" {
+		t.Errorf("Unexpected identity mapping %+v with error: %v", mapping, err)
+	}
+	if list, err := conn.GetIdentityMappingList("zone1"); err != nil || len(list) != 2 {
+		t.Errorf("Expected the mapping in both directions, got %+v with error: %v", list, err)
+	}
+	if err := conn.DeleteIdentityMapping(user.UID.ID, target, true, "zone1"); err != nil {
+		t.Fatalf("DeleteIdentityMapping failed: %s", err)
+	}
+	if list, err := conn.GetIdentityMappingList("zone1"); err != nil || len(list) != 0 {
+		t.Errorf("Expected no identity mappings, got %+v with error: %v", list, err)
+	}
+	if _, err := conn.GetIdentityMapping(user.UID.ID, "zone1"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a removed mapping, got: %v", err)
+	}
+	if err := conn.DeleteIdentityMapping(user.UID.ID, "", false, "zone1"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a missing mapping, got: %v", err)
+	}
+	if err := conn.CreateIdentityMapping(user.UID.ID, target, false, false, "zone1"); err != nil {
+		t.Fatalf("CreateIdentityMapping failed: %s", err)
+	}
+	if err := conn.DeleteIdentityMapping(user.UID.ID, target, false, "missing"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for a missing access zone, got: %v", err)
+	}
+	if _, err := conn.GetIdentityMapping(user.UID.ID, "zone1"); err != nil {
+		t.Errorf("Expected the mapping to be kept, got: %v", err)
+	}
+	replacement := "SID:S-1-5-21-9000-9000-9000-1002"
+	if err := conn.CreateIdentityMapping(user.UID.ID, replacement, false, true, "zone1"); err != nil {
+		t.Fatalf("CreateIdentityMapping failed: %s", err)
+	}
+	mapping, err = conn.GetIdentityMapping(user.UID.ID, "zone1")
+	if err != nil || len(mapping.Targets) != 1 || mapping.Targets[0].Target.ID != replacement {
+		t.Errorf("Expected the existing mapping to be replaced, got %+v with error: %v", mapping, err)
+	}
+
+	// User mapping rules
+	rules := []OnefsUserMappingRule{
+		{
+			Operator: "replace",
+			User1:    OnefsUserMappingRuleUser{Domain: "AD", User: "*"},
+			User2:    &OnefsUserMappingRuleUser{User: "user1"},
+			Options:  &OnefsUserMappingRuleOptions{Break: true},
+		},
+		{
+			Operator: "join",
+			User1:    OnefsUserMappingRuleUser{User: "user1"},
+			User2:    &OnefsUserMappingRuleUser{Domain: "AD", User: "user1"},
+		},
+	}
+	if err := conn.SetUserMappingRules(rules, "zone1"); err != nil {
+		t.Fatalf("SetUserMappingRules failed: %s", err)
+	}
+	current, err := conn.GetUserMappingRules("zone1")
+	if err != nil || !reflect.DeepEqual(current, rules) {
+		t.Errorf("Expected the rules to be stored, got %+v with error: %v", current, err)
+	}
+	if current, err := conn.GetUserMappingRules("System"); err != nil || len(current) != 0 {
+		t.Errorf("Expected no rules in the System zone, got %+v with error: %v", current, err)
+	}
+	if err := conn.SetUserMappingRules(nil, "zone1"); err != nil {
+		t.Fatalf("SetUserMappingRules failed: %s", err)
+	}
+	if current, err := conn.GetUserMappingRules("zone1"); err != nil || len(current) != 0 {
+		t.Errorf("Expected the rules to be removed, got %+v with error: %v", current, err)
+	}
+}
+
+func TestResolvePersonaEmptyUserList(t *testing.T) {
+	// Some providers answer a lookup of an unknown user with an empty list instead of a 404
+	session, srv := newTestSession(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/platform/10/auth/users/SID:S-1-5-21-1-2-3-1001":
+			fmt.Fprint(w, `{"users": []}`)
+		case "/platform/10/auth/groups/SID:S-1-5-21-1-2-3-1001":
+			fmt.Fprint(w, `{"groups": [{"name": "group1", "gid": {"id": "GID:2001"}, "sid": {"id": "SID:S-1-5-21-1-2-3-1001"}}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer srv.Close()
+	conn := &OnefsConn{Papi: session, PlatformPath: "platform/10"}
+	if _, err := conn.GetUser("SID:S-1-5-21-1-2-3-1001", "System"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error for an empty user list, got: %v", err)
+	}
+	persona, err := conn.ResolvePersona("SID:S-1-5-21-1-2-3-1001", "System")
+	if err != nil || persona.Type != "group" || persona.Name != "group1" || persona.GID.ID != "GID:2001" {
+		t.Errorf("Expected the group to be resolved, got %+v with error: %v", persona, err)
+	}
+}
+
+func TestFakeAccessZoneList(t *testing.T) {
+	conn, srv := newFakeConn(t)
+	defer srv.Close()
+	defer conn.Disconnect()
+	srv.AddZone(papitest.Zone{
+		Name:             "zone1",
+		Path:             "/ifs/zone1",
+		CacheEntryExpiry: 3600,
+		UserMappingRules: []string{"DOMAIN\\* &= *[]"},
+	})
+	zones, err := conn.GetAccessZoneList()
+	if err != nil || len(zones) != 2 {
+		t.Fatalf("Expected 2 access zones, got %d with error: %v", len(zones), err)
+	}
+	for _, zone := range zones {
+		if zone.Name == "zone1" && (zone.CacheEntryExpiry != 3600 || len(zone.UserMappingRules) != 1) {
+			t.Errorf("Access zone fields were not decoded: %+v", zone)
+		}
+	}
+	if conn.PlatformPath != "platform/"+papitest.DefaultLatestVersion {
+		t.Errorf("Expected the platform path to use the latest version, got: %s", conn.PlatformPath)
+	}
+}
+
+func TestFakeVersionNegotiation(t *testing.T) {
+	conn, srv := newFakeConn(t)
+	defer srv.Close()
+	defer conn.Disconnect()
+	if conn.APIVersion != 12 || conn.Release != papitest.DefaultRelease {
+		t.Errorf("Expected API version 12 and release %s, got %d and %s", papitest.DefaultRelease, conn.APIVersion, conn.Release)
+	}
+	if !conn.ReleaseAtLeast("9.4") || conn.ReleaseAtLeast("9.4.0.1") || !conn.SupportsAPIVersion(12) || conn.SupportsAPIVersion(13) {
+		t.Errorf("Unexpected version comparison results for release %s", conn.Release)
+	}
+	tests := []struct {
+		platformPath string
+		min          int
+		max          int
+		want         string
+	}{
+		{"platform/12", 3, 0, "platform/12"},
+		{"platform/12", 3, 5, "platform/5"},
+		{"platform/10", 11, 0, "platform/11"},
+		{"platform/10", 1, 0, "platform/10"},
+		{"platform/16", 1, 0, "platform/12"},
+	}
+	for _, tc := range tests {
+		conn.PlatformPath = tc.platformPath
+		path, err := conn.PlatformPathFor("test", tc.min, tc.max)
+		if err != nil || path != tc.want {
+			t.Errorf("PlatformPathFor(%d, %d) with %s: expected %s, got %s with error: %v", tc.min, tc.max, tc.platformPath, tc.want, path, err)
+		}
+	}
+	if _, err := conn.PlatformPathFor("test", 13, 0); !IsUnsupported(err) {
+		t.Errorf("Expected an unsupported error, got: %v", err)
+	}
+
+	// Without a discovered version the configured path is used as is and no version is assumed to be unsupported
+	conn.APIVersion = 0
+	conn.PlatformPath = "platform/10"
+	if path, err := conn.PlatformPathFor("test", 14, 0); err != nil || path != "platform/10" {
+		t.Errorf("Expected platform/10 for an unknown cluster version, got %s with error: %v", path, err)
+	}
+
+	// An older cluster without S3 support returns a clear error without sending the request
+	srv.SetLatestVersion("9")
+	srv.SetRelease("8.2.2.0")
+	if err := conn.Connect(&OnefsCfg{User: papitest.DefaultUser, Password: papitest.DefaultPassword, Endpoint: srv.URL}); err != nil {
+		t.Fatalf("Unable to reconnect: %s", err)
+	}
+	if conn.PlatformPath != "platform/9" || conn.Release != "8.2.2.0" {
+		t.Errorf("Expected platform/9 and release 8.2.2.0, got %s and %s", conn.PlatformPath, conn.Release)
+	}
+	_, err := conn.GetS3Token("user1", "System", 0)
+	var unsupported *UnsupportedError
+	if !errors.As(err, &unsupported) || unsupported.MinVersion != 10 || unsupported.APIVersion != 9 {
+		t.Errorf("Expected an unsupported error for S3 keys, got: %v", err)
+	}
+}
+
+func TestClusterPool(t *testing.T) {
+	pool := NewClusterPool()
+	pool.MaxParallel = 2
+	defer pool.Close()
+	for i := 0; i < 4; i++ {
+		srv := papitest.NewServer()
+		defer srv.Close()
+		srv.AddUser("System", papitest.User{Name: fmt.Sprintf("user%d", i)})
+		pool.Add(fmt.Sprintf("cluster%d", i), &OnefsCfg{
+			User:     papitest.DefaultUser,
+			Password: papitest.DefaultPassword,
+			Endpoint: srv.URL,
+		})
+	}
+	pool.Add("down", &OnefsCfg{User: "admin", Password: "password", Endpoint: "http://127.0.0.1:1/", Logger: NopLogger{}})
+
+	var running, maxRunning int32
+	results := pool.ForEach(func(ctx context.Context, name string, conn *OnefsConn) (interface{}, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return conn.GetUserListContext(ctx, "System")
+	})
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %d", len(results))
+	}
+	if maxRunning > 2 {
+		t.Errorf("Expected at most 2 concurrent calls, got %d", maxRunning)
+	}
+	for i, result := range results[:4] {
+		users, ok := result.Value.([]OnefsUser)
+		if result.Err != nil || !ok || len(users) != 1 || users[0].Name != fmt.Sprintf("user%d", i) {
+			t.Errorf("Unexpected result for %s: %v %v", result.Name, result.Value, result.Err)
+		}
+	}
+	if results[4].Name != "down" || results[4].Err == nil {
+		t.Errorf("Expected a connection error for the unreachable cluster, got: %v", results[4])
+	}
+	if err := results.Err(); err == nil {
+		t.Errorf("Expected the combined error to report the unreachable cluster")
+	}
+
+	// Connections are created once and reused
+	first, err := pool.Conn("cluster0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := pool.Conn("cluster0")
+	if first != second {
+		t.Errorf("Expected the connection to be reused")
+	}
+	if _, err := pool.Conn("missing"); err == nil {
+		t.Errorf("Expected an error for an unknown cluster")
+	}
+}
@@ -0,0 +1,148 @@
+package papilite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UnsupportedError is returned by calls that need a platform API version the connected cluster does not support
+type UnsupportedError struct {
+	// Feature describes the call or resource that is not supported, e.g. "S3 keys"
+	Feature string
+	// MinVersion is the lowest platform API version that supports the feature
+	MinVersion int
+	// APIVersion and Release are the latest platform API version and the OneFS release of the cluster
+	APIVersion int
+	Release    string
+}
+
+// Error returns a string version of the error including the required and the supported API version
+func (e *UnsupportedError) Error() string {
+	release := e.Release
+	if release == "" {
+		release = "unknown"
+	}
+	if e.APIVersion == 0 {
+		return fmt.Sprintf("%s is not supported on this cluster: requires platform API version %d or later but the platform API version of the cluster (OneFS release %s) is unknown", e.Feature, e.MinVersion, release)
+	}
+	return fmt.Sprintf("%s is not supported on this cluster: requires platform API version %d or later but the cluster (OneFS release %s) supports up to version %d", e.Feature, e.MinVersion, release, e.APIVersion)
+}
+
+// IsUnsupported returns true if err is an UnsupportedError
+func IsUnsupported(err error) bool {
+	var unsupported *UnsupportedError
+	return errors.As(err, &unsupported)
+}
+
+// GetClusterConfig returns the general configuration of the cluster including the OneFS version
+func (conn *OnefsConn) GetClusterConfig() (*OnefsClusterConfig, error) {
+	return conn.GetClusterConfigContext(context.Background())
+}
+
+// GetClusterConfigContext is the same as GetClusterConfig but the request is bound to the passed in context.Context
+func (conn *OnefsConn) GetClusterConfigContext(ctx context.Context) (*OnefsClusterConfig, error) {
+	var result OnefsClusterConfig
+	err := conn.Papi.SendIntoContext(
+		ctx,
+		"GET",
+		defaultPapiWrapperConfigPath,
+		nil, // query args
+		nil, // body
+		nil, // extra headers
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.Logger().Debug("[GetClusterConfig] Response", "json", debugJSON(result))
+	return &result, nil
+}
+
+// discoverVersion sets the APIVersion, Release and PlatformPath of the connection from the connected cluster. Failures
+// are logged and leave the values empty so the default PlatformPath is used
+func (conn *OnefsConn) discoverVersion(ctx context.Context) {
+	conn.APIVersion = 0
+	conn.Release = ""
+	apiVer, err := conn.GetPlatformLatestContext(ctx)
+	if err != nil {
+		conn.Logger().Warn("[Connect] Unable to get latest platform API version automatically", "error", err)
+	} else if version, err := strconv.Atoi(apiVer); err != nil {
+		conn.Logger().Warn("[Connect] Invalid latest platform API version", "version", apiVer)
+	} else {
+		conn.APIVersion = version
+		conn.PlatformPath = "platform/" + apiVer
+	}
+	config, err := conn.GetClusterConfigContext(ctx)
+	if err != nil {
+		conn.Logger().Warn("[Connect] Unable to get OneFS release", "error", err)
+		return
+	}
+	conn.Release = config.OnefsVersion.Release
+	conn.Logger().Debug("[Connect] Discovered cluster version", "release", conn.Release, "api_version", conn.APIVersion)
+}
+
+// PlatformPathFor returns the platform path to use for a call that needs at least platform API version min and
+// understands responses up to version max. A max of 0 means there is no upper limit.
+// The version of PlatformPath is used when it is in range. Otherwise the closest version in range is selected as long
+// as the cluster supports it. If the cluster does not support min an UnsupportedError is returned instead of letting
+// the call fail with a 404. When the version of the cluster is unknown PlatformPath is returned unchanged.
+func (conn *OnefsConn) PlatformPathFor(feature string, min int, max int) (string, error) {
+	supported := conn.APIVersion
+	if supported == 0 {
+		// The configured path says nothing about what the cluster supports so the call is sent unchanged
+		return conn.PlatformPath, nil
+	}
+	current, err := strconv.Atoi(strings.TrimPrefix(conn.PlatformPath, "platform/"))
+	if err != nil {
+		current = 0
+	}
+	if supported < min {
+		return "", &UnsupportedError{Feature: feature, MinVersion: min, APIVersion: supported, Release: conn.Release}
+	}
+	version := current
+	if version == 0 || version > supported {
+		version = supported
+	}
+	if version < min {
+		version = min
+	}
+	if max > 0 && version > max {
+		version = max
+	}
+	return "platform/" + strconv.Itoa(version), nil
+}
+
+// SupportsAPIVersion returns true if the connected cluster supports the given platform API version. It returns false
+// if the version of the cluster is unknown
+func (conn *OnefsConn) SupportsAPIVersion(version int) bool {
+	return conn.APIVersion >= version && conn.APIVersion > 0
+}
+
+// ReleaseAtLeast returns true if the OneFS release of the connected cluster is the same or later than release, e.g.
+// "9.5" or "9.5.0.0". Missing release components are treated as 0. It returns false if the release is unknown
+func (conn *OnefsConn) ReleaseAtLeast(release string) bool {
+	if conn.Release == "" {
+		return false
+	}
+	have := strings.Split(conn.Release, ".")
+	want := strings.Split(release, ".")
+	for i := 0; i < len(have) || i < len(want); i++ {
+		h, w := releaseComponent(have, i), releaseComponent(want, i)
+		if h != w {
+			return h > w
+		}
+	}
+	return true
+}
+
+// releaseComponent returns the numeric release component at index i or 0 if it does not exist or is not a number
+func releaseComponent(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	value, _ := strconv.Atoi(parts[i])
+	return value
+}
@@ -0,0 +1,282 @@
+package papitest
+
+import (
+	"net/http"
+	"strings"
+)
+
+// identityMapping is a single identity mapping from a source persona to a target persona in an access zone
+type identityMapping struct {
+	source ID
+	target ID
+	kind   string
+}
+
+// personaRef returns the reference used in error messages for a persona given either as a plain name or as a
+// <type>:<value> reference
+func personaRef(prefix string, value string) string {
+	if strings.Contains(value, ":") {
+		return value
+	}
+	return prefix + ":" + value
+}
+
+// findUser returns the user given either as a plain name or as USER:<name>, UID:<uid> or SID:<sid>
+func (s *Server) findUser(zone string, ref string) (*User, bool) {
+	if strings.HasPrefix(ref, "UID:") || strings.HasPrefix(ref, "SID:") {
+		for _, user := range s.users[zone] {
+			if user.UID.ID == ref || user.SID.ID == ref {
+				return user, true
+			}
+		}
+		return nil, false
+	}
+	user, ok := s.users[zone][strings.TrimPrefix(ref, "USER:")]
+	return user, ok
+}
+
+// findGroup returns the group given either as a plain name or as GROUP:<name>, GID:<gid> or SID:<sid>
+func (s *Server) findGroup(zone string, ref string) (*Group, bool) {
+	if strings.HasPrefix(ref, "GID:") || strings.HasPrefix(ref, "SID:") {
+		for _, group := range s.groups[zone] {
+			if group.GID.ID == ref || group.SID.ID == ref {
+				return group, true
+			}
+		}
+		return nil, false
+	}
+	group, ok := s.groups[zone][strings.TrimPrefix(ref, "GROUP:")]
+	return group, ok
+}
+
+// identityID returns the ID of a persona reference with the name and type filled in when the persona is a known user
+// or group
+func (s *Server) identityID(zone string, ref string) ID {
+	if strings.HasPrefix(ref, "UID:") || strings.HasPrefix(ref, "SID:") {
+		if user, ok := s.findUser(zone, ref); ok {
+			return ID{ID: ref, Name: user.Name, Type: "user"}
+		}
+	}
+	if strings.HasPrefix(ref, "GID:") || strings.HasPrefix(ref, "SID:") {
+		if group, ok := s.findGroup(zone, ref); ok {
+			return ID{ID: ref, Name: group.Name, Type: "group"}
+		}
+	}
+	return ID{ID: ref}
+}
+
+// identityObjects groups the identity mappings of an access zone by source persona. When source is not empty only the
+// mappings of that source are returned
+func (s *Server) identityObjects(zone string, source string) []interface{} {
+	var order []string
+	targets := map[string][]interface{}{}
+	sources := map[string]ID{}
+	for _, mapping := range s.identities[zone] {
+		if source != "" && mapping.source.ID != source {
+			continue
+		}
+		if _, ok := sources[mapping.source.ID]; !ok {
+			order = append(order, mapping.source.ID)
+			sources[mapping.source.ID] = mapping.source
+		}
+		targets[mapping.source.ID] = append(targets[mapping.source.ID], map[string]interface{}{
+			"on_disk": false,
+			"target":  mapping.target,
+			"type":    mapping.kind,
+		})
+	}
+	var result []interface{}
+	for _, id := range order {
+		result = append(result, map[string]interface{}{"source": sources[id], "targets": targets[id]})
+	}
+	return result
+}
+
+// removeIdentities removes the mappings from source to target. All mappings of source are removed if target is empty
+func (s *Server) removeIdentities(zone string, source string, target string) {
+	var kept []*identityMapping
+	for _, mapping := range s.identities[zone] {
+		if mapping.source.ID == source && (target == "" || mapping.target.ID == target) {
+			continue
+		}
+		kept = append(kept, mapping)
+	}
+	s.identities[zone] = kept
+}
+
+// handleIdentities implements platform/<version>/auth/mapping/identities and
+// platform/<version>/auth/mapping/identities/<source>
+func (s *Server) handleIdentities(w http.ResponseWriter, r *http.Request, zone string, source string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	if source == "" {
+		switch r.Method {
+		case "GET":
+			s.writeList(w, r, "identities", s.identityObjects(zone, ""))
+		case "POST":
+			var req struct {
+				Source string `json:"source"`
+				Target string `json:"target"`
+			}
+			if !decodeBody(w, r, &req) {
+				return
+			}
+			if req.Source == "" || req.Target == "" {
+				writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", "Field: source and target required")
+				return
+			}
+			query := r.URL.Query()
+			if query.Get("replace") != "" {
+				s.removeIdentities(zone, req.Source, "")
+			}
+			s.removeIdentities(zone, req.Source, req.Target)
+			kind, reverse := "forward", "reverse"
+			if query.Get("2way") != "" {
+				kind, reverse = "equivalent", "equivalent"
+			}
+			sourceID, targetID := s.identityID(zone, req.Source), s.identityID(zone, req.Target)
+			s.removeIdentities(zone, req.Target, req.Source)
+			s.identities[zone] = append(
+				s.identities[zone],
+				&identityMapping{source: sourceID, target: targetID, kind: kind},
+				&identityMapping{source: targetID, target: sourceID, kind: reverse},
+			)
+			writeJSON(w, http.StatusCreated, map[string]interface{}{})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		}
+		return
+	}
+	switch r.Method {
+	case "GET":
+		items := s.identityObjects(zone, source)
+		if len(items) == 0 {
+			writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find identity mapping for '"+source+"'")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"identities": items})
+	case "DELETE":
+		query := r.URL.Query()
+		target := query.Get("target")
+		if len(s.identityObjects(zone, source)) == 0 {
+			writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find identity mapping for '"+source+"'")
+			return
+		}
+		if query.Get("2way") != "" {
+			for _, mapping := range s.identities[zone] {
+				if mapping.source.ID == source && (target == "" || mapping.target.ID == target) {
+					s.removeIdentities(zone, mapping.target.ID, source)
+				}
+			}
+		}
+		s.removeIdentities(zone, source, target)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleMappingUsersRules implements platform/<version>/auth/mapping/users/rules
+func (s *Server) handleMappingUsersRules(w http.ResponseWriter, r *http.Request, zone string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	switch r.Method {
+	case "GET":
+		rules := s.mappingRules[zone]
+		if rules == nil {
+			rules = []interface{}{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"rules": map[string]interface{}{
+				"parameters": map[string]interface{}{},
+				"rules":      rules,
+			},
+		})
+	case "PUT":
+		var req struct {
+			Rules struct {
+				Rules []interface{} `json:"rules"`
+			} `json:"rules"`
+		}
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		s.mappingRules[zone] = req.Rules.Rules
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleMappingUsersLookup implements platform/<version>/auth/mapping/users/lookup. The user is given with the user
+// query argument either as a plain name or as USER:<name>, UID:<uid> or SID:<sid>
+func (s *Server) handleMappingUsersLookup(w http.ResponseWriter, r *http.Request, zone string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		return
+	}
+	ref := r.URL.Query().Get("user")
+	user, ok := s.findUser(zone, ref)
+	if !ok {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find user for '"+personaRef("USER", ref)+"': No such user")
+		return
+	}
+	primary := map[string]interface{}{"name": user.PrimaryGroup.Name, "gid": user.PrimaryGroup}
+	primarySID := ID{}
+	if group, ok := s.findGroup(zone, user.PrimaryGroup.ID); ok {
+		primarySID = group.SID
+		primary["sid"] = group.SID
+	}
+	groups := []interface{}{primary}
+	for _, member := range s.memberOf(zone, ID{Name: user.Name, Type: "user"}) {
+		group := s.groups[zone][member.Name]
+		groups = append(groups, map[string]interface{}{"name": group.Name, "gid": group.GID, "sid": group.SID})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"mapping": []map[string]interface{}{{
+			"user": map[string]interface{}{
+				"name":                  user.Name,
+				"uid":                   user.UID,
+				"sid":                   user.SID,
+				"on_disk_user_identity": user.OnDiskUserIdentity,
+				"primary_group_name":    user.PrimaryGroup.Name,
+				"primary_group_sid":     primarySID,
+			},
+			"groups":     groups,
+			"privileges": s.effectivePrivileges(zone, user.Name),
+			"zid":        s.zones[zone].ZoneID,
+			"zone":       zone,
+		}},
+	})
+}
+
+// handleAuthID implements platform/<version>/auth/id and returns the access token of the user making the request
+func (s *Server) handleAuthID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		return
+	}
+	name, _, ok := r.BasicAuth()
+	if !ok {
+		if _, sess := s.session(r); sess != nil {
+			name = sess.user
+		}
+	}
+	uid := ID{ID: "UID:0", Name: name, Type: "user"}
+	if user, ok := s.users[SystemZone][name]; ok {
+		uid = user.UID
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ntoken": map[string]interface{}{
+			"uid":       uid,
+			"privilege": s.effectivePrivileges(SystemZone, name),
+			"zid":       s.zones[SystemZone].ZoneID,
+			"zone_name": SystemZone,
+		},
+	})
+}
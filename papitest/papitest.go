@@ -0,0 +1,514 @@
+// Package papitest provides an in-process fake OneFS PAPI server for testing code that uses go-papi-lite without a
+// live cluster. The server is built on net/http/httptest and implements the session service (cookies, CSRF tokens and
+// session expiry), HTTP basic authentication, platform/latest, cluster/config, resume token pagination and in-memory
+// access zones, users, groups, RBAC roles, authentication providers, identity mappings, user mapping rules and S3 keys.
+// User mapping lookups and auth/id report the privileges granted by the roles.
+//
+// Time based behavior like session expiry uses a controllable clock so a session timeout can be tested without
+// sleeping.
+//
+// The package also provides a Recorder transport that captures request and response pairs against a real cluster
+// with credentials and tokens scrubbed, and a Replayer transport that serves a captured fixture back deterministically.
+//
+//	srv := papitest.NewServer()
+//	defer srv.Close()
+//	srv.AddUser("System", papitest.User{Name: "user1"})
+//	conn := papilite.NewPapiConn()
+//	err := conn.Connect(&papilite.OnefsCfg{
+//		User:     papitest.DefaultUser,
+//		Password: papitest.DefaultPassword,
+//		Endpoint: srv.URL,
+//	})
+//	users, err := conn.GetUserList("System")
+package papitest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultUser is the user name accepted by a new Server
+	DefaultUser string = "admin"
+	// DefaultPassword is the password accepted by a new Server
+	DefaultPassword string = "password"
+	// DefaultLatestVersion is the PAPI version returned by platform/latest
+	DefaultLatestVersion string = "12"
+	// DefaultRelease is the OneFS release returned by platform/1/cluster/config
+	DefaultRelease string = "9.4.0.0"
+	// DefaultPageSize is the maximum number of items returned in a single page of a list request
+	DefaultPageSize int = 1000
+	// DefaultSessionTimeout is the inactivity timeout of a session. This matches the OneFS default
+	DefaultSessionTimeout time.Duration = 900 * time.Second
+	// DefaultSessionMaxAge is the absolute timeout of a session. This matches the OneFS default
+	DefaultSessionMaxAge time.Duration = 4 * time.Hour
+	// SystemZone is the name of the access zone that always exists
+	SystemZone  string = "System"
+	sessionPath string = "session/1/session"
+)
+
+// Server is a fake PAPI server. All methods are safe for concurrent use.
+type Server struct {
+	// URL is the base URL of the server in the form http://127.0.0.1:port and can be used directly as the endpoint
+	URL string
+
+	httpServer     *httptest.Server
+	mu             sync.Mutex
+	user           string
+	password       string
+	latest         string
+	release        string
+	pageSize       int
+	sessionTimeout time.Duration
+	sessionMaxAge  time.Duration
+	now            func() time.Time
+	offset         time.Duration
+	sessions       map[string]*session
+	resumeTokens   map[string]*cursor
+	zones          map[string]*Zone
+	users          map[string]map[string]*User
+	groups         map[string]map[string]*Group
+	s3Keys         map[string]map[string]*S3Key
+	roles          map[string]map[string]*Role
+	providers      map[string]map[string]*Provider
+	identities     map[string][]*identityMapping
+	mappingRules   map[string][]interface{}
+	nextID         int
+	requests       []string
+}
+
+// session is the state of a session created with the session service
+type session struct {
+	csrf     string
+	user     string
+	created  time.Time
+	lastUsed time.Time
+}
+
+// cursor is the state behind a resume token
+type cursor struct {
+	key    string
+	items  []interface{}
+	offset int
+}
+
+// NewServer starts and returns a new Server listening on a local HTTP port. The server contains the System access zone
+// and accepts DefaultUser and DefaultPassword. The caller must call Close when done
+func NewServer() *Server {
+	s := newServer()
+	s.httpServer = httptest.NewServer(s)
+	s.URL = s.httpServer.URL
+	return s
+}
+
+// NewTLSServer is the same as NewServer but the server uses HTTPS with a self-signed certificate. Use HTTPServer
+// to get the certificate or set IgnoreCert on the session
+func NewTLSServer() *Server {
+	s := newServer()
+	s.httpServer = httptest.NewTLSServer(s)
+	s.URL = s.httpServer.URL
+	return s
+}
+
+func newServer() *Server {
+	s := &Server{
+		user:           DefaultUser,
+		password:       DefaultPassword,
+		latest:         DefaultLatestVersion,
+		release:        DefaultRelease,
+		pageSize:       DefaultPageSize,
+		sessionTimeout: DefaultSessionTimeout,
+		sessionMaxAge:  DefaultSessionMaxAge,
+		now:            time.Now,
+		sessions:       map[string]*session{},
+		resumeTokens:   map[string]*cursor{},
+		zones:          map[string]*Zone{},
+		users:          map[string]map[string]*User{},
+		groups:         map[string]map[string]*Group{},
+		s3Keys:         map[string]map[string]*S3Key{},
+		roles:          map[string]map[string]*Role{},
+		providers:      map[string]map[string]*Provider{},
+		identities:     map[string][]*identityMapping{},
+		mappingRules:   map[string][]interface{}{},
+		nextID:         2000,
+	}
+	s.AddZone(Zone{Name: SystemZone, Path: "/ifs", System: true})
+	s.addProvider(&Provider{Type: "file", Name: SystemZone, Settings: map[string]interface{}{"password_file": "/etc/master.passwd"}})
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// HTTPServer returns the underlying httptest.Server, e.g. to get the TLS certificate or a preconfigured client
+func (s *Server) HTTPServer() *httptest.Server {
+	return s.httpServer
+}
+
+// SetCredentials changes the user name and password accepted by the server
+func (s *Server) SetCredentials(user string, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = user
+	s.password = password
+}
+
+// SetLatestVersion changes the version returned by platform/latest. Requests to platform versions above the latest
+// version fail with a 404
+func (s *Server) SetLatestVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest = version
+}
+
+// SetRelease changes the OneFS release returned by platform/1/cluster/config
+func (s *Server) SetRelease(release string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release = release
+}
+
+// SetPageSize changes the maximum number of items returned in a single page. Smaller pages force clients to follow
+// resume tokens
+func (s *Server) SetPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageSize = n
+}
+
+// SetSessionTimeout changes the inactivity and absolute timeouts of sessions
+func (s *Server) SetSessionTimeout(inactive time.Duration, absolute time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessionTimeout = inactive
+	s.sessionMaxAge = absolute
+}
+
+// SetClock replaces the function used to get the current time. Advance still adds its offset to the returned time
+func (s *Server) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// Advance moves the clock of the server forward by d. This is used to expire sessions without sleeping
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// ExpireSessions invalidates all existing sessions. Clients must re-authenticate on their next request
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = map[string]*session{}
+}
+
+// SessionCount returns the number of sessions that currently exist
+func (s *Server) SessionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// Requests returns a list of all requests received by the server in the form "METHOD /path"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// clock returns the current time of the server. The caller must hold the lock
+func (s *Server) clock() time.Time {
+	return s.now().Add(s.offset)
+}
+
+// newID returns a new unique numeric ID. The caller must hold the lock
+func (s *Server) newID() int {
+	s.nextID++
+	return s.nextID
+}
+
+// ServeHTTP handles all requests to the server
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	path := strings.Trim(r.URL.Path, "/")
+	if path == sessionPath {
+		s.handleSession(w, r)
+		return
+	}
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "AEC_UNAUTHORIZED", "Authorization required")
+		return
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || parts[0] != "platform" {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Path not found: "+r.URL.Path)
+		return
+	}
+	if parts[1] == "latest" && len(parts) == 2 {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"latest": s.latest})
+		return
+	}
+	version, err := strconv.Atoi(parts[1])
+	latest, _ := strconv.Atoi(s.latest)
+	if err != nil || version < 1 || version > latest {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Path not found: "+r.URL.Path)
+		return
+	}
+	if resume := r.URL.Query().Get("resume"); resume != "" && r.Method == "GET" {
+		s.handleResume(w, resume)
+		return
+	}
+	s.handlePlatform(w, r, parts[2:])
+}
+
+// handleSession implements the session service
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		var req struct {
+			Username string   `json:"username"`
+			Password string   `json:"password"`
+			Services []string `json:"services"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", "Invalid JSON body: "+err.Error())
+			return
+		}
+		if req.Username != s.user || req.Password != s.password {
+			writeError(w, http.StatusUnauthorized, "AEC_UNAUTHORIZED", "Username or password is incorrect.")
+			return
+		}
+		token := randomToken()
+		csrf := randomToken()
+		now := s.clock()
+		s.sessions[token] = &session{csrf: csrf, user: req.Username, created: now, lastUsed: now}
+		http.SetCookie(w, &http.Cookie{Name: "isisessid", Value: token, Path: "/", HttpOnly: true, Secure: true})
+		http.SetCookie(w, &http.Cookie{Name: "isicsrf", Value: csrf, Path: "/", Secure: true})
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"services":         req.Services,
+			"timeout_absolute": int(s.sessionMaxAge.Seconds()),
+			"timeout_inactive": int(s.sessionTimeout.Seconds()),
+			"username":         req.Username,
+		})
+	case "GET":
+		token, sess := s.session(r)
+		if sess == nil {
+			writeError(w, http.StatusUnauthorized, "AEC_UNAUTHORIZED", "Authorization required")
+			return
+		}
+		sess.lastUsed = s.clock()
+		s.sessions[token] = sess
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"services":         []string{"platform", "namespace"},
+			"timeout_absolute": int(s.sessionMaxAge.Seconds()),
+			"timeout_inactive": int(s.sessionTimeout.Seconds()),
+			"username":         sess.user,
+		})
+	case "DELETE":
+		token, sess := s.session(r)
+		if sess == nil {
+			writeError(w, http.StatusUnauthorized, "AEC_UNAUTHORIZED", "Authorization required")
+			return
+		}
+		delete(s.sessions, token)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// session returns the valid, unexpired session referenced by the cookie of a request. Expired sessions are removed
+func (s *Server) session(r *http.Request) (string, *session) {
+	cookie, err := r.Cookie("isisessid")
+	if err != nil {
+		return "", nil
+	}
+	sess, ok := s.sessions[cookie.Value]
+	if !ok {
+		return "", nil
+	}
+	now := s.clock()
+	if now.Sub(sess.lastUsed) > s.sessionTimeout || now.Sub(sess.created) > s.sessionMaxAge {
+		delete(s.sessions, cookie.Value)
+		return "", nil
+	}
+	return cookie.Value, sess
+}
+
+// authorized checks the basic authentication credentials or the session cookie and CSRF token of a request
+func (s *Server) authorized(r *http.Request) bool {
+	if user, password, ok := r.BasicAuth(); ok {
+		return user == s.user && password == s.password
+	}
+	_, sess := s.session(r)
+	if sess == nil || r.Header.Get("X-CSRF-Token") != sess.csrf {
+		return false
+	}
+	sess.lastUsed = s.clock()
+	return true
+}
+
+// handlePlatform routes a request below platform/<version>
+func (s *Server) handlePlatform(w http.ResponseWriter, r *http.Request, parts []string) {
+	zone := r.URL.Query().Get("zone")
+	if zone == "" {
+		zone = SystemZone
+	}
+	route := strings.Join(parts, "/")
+	switch {
+	case route == "cluster/config":
+		s.handleClusterConfig(w, r)
+	case route == "zones":
+		s.handleZones(w, r)
+	case route == "auth/users":
+		s.handleUsers(w, r, zone)
+	case len(parts) == 3 && route == "auth/users/"+parts[2]:
+		s.handleUser(w, r, zone, parts[2])
+	case len(parts) == 4 && route == "auth/users/"+parts[2]+"/change-password":
+		s.handleChangePassword(w, r, zone, parts[2])
+	case route == "auth/groups":
+		s.handleGroups(w, r, zone)
+	case len(parts) == 3 && route == "auth/groups/"+parts[2]:
+		s.handleGroup(w, r, zone, parts[2])
+	case len(parts) == 4 && parts[0] == "auth" && parts[1] == "groups" && parts[3] == "members":
+		s.handleGroupMembers(w, r, zone, parts[2])
+	case len(parts) == 5 && parts[0] == "auth" && parts[1] == "groups" && parts[3] == "members":
+		s.handleGroupMember(w, r, zone, parts[2], parts[4])
+	case len(parts) >= 2 && parts[0] == "auth" && parts[1] == "roles":
+		s.handleRoles(w, r, zone, parts[2:])
+	case route == "auth/providers/summary":
+		s.handleProviderSummary(w, r)
+	case len(parts) == 3 && parts[0] == "auth" && parts[1] == "providers":
+		s.handleProviders(w, r, parts[2], "")
+	case len(parts) == 4 && parts[0] == "auth" && parts[1] == "providers":
+		s.handleProviders(w, r, parts[2], parts[3])
+	case len(parts) == 4 && parts[0] == "auth" && parts[1] == "ads" && parts[3] == "spn":
+		s.handleAdsSPN(w, r, parts[2])
+	case route == "auth/privileges":
+		s.handlePrivileges(w, r)
+	case route == "auth/mapping/users/lookup":
+		s.handleMappingUsersLookup(w, r, zone)
+	case route == "auth/mapping/users/rules":
+		s.handleMappingUsersRules(w, r, zone)
+	case route == "auth/mapping/identities":
+		s.handleIdentities(w, r, zone, "")
+	case len(parts) == 4 && route == "auth/mapping/identities/"+parts[3]:
+		s.handleIdentities(w, r, zone, parts[3])
+	case route == "auth/id":
+		s.handleAuthID(w, r)
+	case len(parts) == 4 && route == "protocols/s3/keys/"+parts[3]:
+		s.handleS3Keys(w, r, zone, parts[3])
+	default:
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Path not found: "+r.URL.Path)
+	}
+}
+
+// writeList writes a page of items under key. If there are more items than fit in a page a resume token is returned
+func (s *Server) writeList(w http.ResponseWriter, r *http.Request, key string, items []interface{}) {
+	pageSize := s.pageSize
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 && limit < pageSize {
+		pageSize = limit
+	}
+	s.writePage(w, &cursor{key: key, items: items}, pageSize)
+}
+
+// writePage writes the page of a cursor starting at its offset
+func (s *Server) writePage(w http.ResponseWriter, c *cursor, pageSize int) {
+	end := c.offset + pageSize
+	if end > len(c.items) {
+		end = len(c.items)
+	}
+	page := map[string]interface{}{
+		c.key:    c.items[c.offset:end],
+		"total":  len(c.items),
+		"resume": nil,
+	}
+	if end < len(c.items) {
+		token := randomToken()
+		s.resumeTokens[token] = &cursor{key: c.key, items: c.items, offset: end}
+		page["resume"] = token
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleResume writes the next page for a resume token. Resume tokens can only be used once
+func (s *Server) handleResume(w http.ResponseWriter, token string) {
+	c, ok := s.resumeTokens[token]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", "Invalid resume token")
+		return
+	}
+	delete(s.resumeTokens, token)
+	s.writePage(w, c, s.pageSize)
+}
+
+// randomToken returns a random hex string used for session IDs, CSRF and resume tokens
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sortedKeys returns the keys of a map in sorted order so lists are returned in a stable order
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch val := m.(type) {
+	case map[string]*Zone:
+		for k := range val {
+			keys = append(keys, k)
+		}
+	case map[string]*User:
+		for k := range val {
+			keys = append(keys, k)
+		}
+	case map[string]*Group:
+		for k := range val {
+			keys = append(keys, k)
+		}
+	case map[string]*Role:
+		for k := range val {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeJSON writes obj as a JSON response
+func writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(obj)
+}
+
+// writeError writes an error response in the format used by PAPI
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
+
+// decodeBody decodes the JSON body of a request into obj and writes an error response on failure
+func decodeBody(w http.ResponseWriter, r *http.Request, obj interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(obj); err != nil {
+		writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", fmt.Sprintf("Invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
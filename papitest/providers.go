@@ -0,0 +1,260 @@
+package papitest
+
+import (
+	"net/http"
+	"sort"
+)
+
+// Provider is an authentication provider. Type is one of ads, ldap, nis, file or local. Settings contains any other
+// provider specific values, e.g. base_dn for an LDAP provider, that are returned as part of the provider
+type Provider struct {
+	Type     string
+	Name     string
+	Status   string
+	ZoneName string
+	Settings map[string]interface{}
+	// SPNs are the service principal names registered for an AD provider
+	SPNs []string
+}
+
+// providerPrefix maps the provider type to the prefix of the provider ID
+var providerPrefix = map[string]string{
+	"ads":   "lsa-activedirectory-provider",
+	"ldap":  "lsa-ldap-provider",
+	"nis":   "lsa-nis-provider",
+	"file":  "lsa-file-provider",
+	"local": "lsa-local-provider",
+}
+
+// id returns the provider ID in the form used in the auth_providers list of an access zone
+func (p *Provider) id() string {
+	return providerPrefix[p.Type] + ":" + p.Name
+}
+
+// object returns the JSON representation of the provider
+func (p *Provider) object() map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range p.Settings {
+		result[k] = v
+	}
+	result["id"] = p.Name
+	result["name"] = p.Name
+	result["status"] = p.Status
+	if p.ZoneName != "" {
+		result["zone_name"] = p.ZoneName
+	}
+	return result
+}
+
+// expectedSPNs returns the service principal names an AD provider should have registered
+func (p *Provider) expectedSPNs() []string {
+	hostname, _ := p.Settings["hostname"].(string)
+	if hostname == "" {
+		hostname = "papitest." + p.Name
+	}
+	return []string{"HOST/" + hostname, "HOST/papitest"}
+}
+
+// AddProvider adds or replaces an authentication provider. The status defaults to online
+func (s *Server) AddProvider(provider Provider) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addProvider(&provider)
+}
+
+// addProvider completes the values of a provider and stores it. The caller must hold the lock
+func (s *Server) addProvider(provider *Provider) {
+	if provider.Status == "" {
+		provider.Status = "online"
+	}
+	if provider.Settings == nil {
+		provider.Settings = map[string]interface{}{}
+	}
+	if s.providers[provider.Type] == nil {
+		s.providers[provider.Type] = map[string]*Provider{}
+	}
+	s.providers[provider.Type][provider.Name] = provider
+}
+
+// SetProviderStatus changes the status of a provider, e.g. to offline, to simulate a provider outage
+func (s *Server) SetProviderStatus(providerType string, name string, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if provider, ok := s.providers[providerType][name]; ok {
+		provider.Status = status
+	}
+}
+
+// Provider returns a copy of an authentication provider or nil if it does not exist
+func (s *Server) Provider(providerType string, name string) *Provider {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	provider, ok := s.providers[providerType][name]
+	if !ok {
+		return nil
+	}
+	result := *provider
+	result.Settings = map[string]interface{}{}
+	for k, v := range provider.Settings {
+		result.Settings[k] = v
+	}
+	result.SPNs = append([]string(nil), provider.SPNs...)
+	return &result
+}
+
+// sortedProviders returns the providers of a type sorted by name
+func (s *Server) sortedProviders(providerType string) []*Provider {
+	var result []*Provider
+	for _, provider := range s.providers[providerType] {
+		result = append(result, provider)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// handleProviders implements platform/<version>/auth/providers/<type> and platform/<version>/auth/providers/<type>/<name>
+func (s *Server) handleProviders(w http.ResponseWriter, r *http.Request, providerType string, name string) {
+	if _, ok := providerPrefix[providerType]; !ok {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Path not found: "+r.URL.Path)
+		return
+	}
+	if name == "" {
+		switch r.Method {
+		case "GET":
+			zone := r.URL.Query().Get("zone")
+			var items []interface{}
+			for _, provider := range s.sortedProviders(providerType) {
+				if zone == "" || provider.ZoneName == "" || provider.ZoneName == zone {
+					items = append(items, provider.object())
+				}
+			}
+			s.writeList(w, r, providerType, items)
+		case "POST":
+			var settings map[string]interface{}
+			if !decodeBody(w, r, &settings) {
+				return
+			}
+			name, _ := settings["name"].(string)
+			if name == "" {
+				writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", "Field: name required")
+				return
+			}
+			if _, ok := s.providers[providerType][name]; ok {
+				writeError(w, http.StatusConflict, "AEC_CONFLICT", "Provider already exists")
+				return
+			}
+			// Credentials used to join a domain are not stored with the provider
+			delete(settings, "name")
+			delete(settings, "user")
+			delete(settings, "password")
+			provider := &Provider{Type: providerType, Name: name, Settings: settings}
+			provider.ZoneName, _ = settings["zone_name"].(string)
+			if providerType == "ads" {
+				provider.SPNs = provider.expectedSPNs()
+			}
+			s.addProvider(provider)
+			writeJSON(w, http.StatusCreated, map[string]interface{}{"id": provider.id()})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		}
+		return
+	}
+	zone := r.URL.Query().Get("zone")
+	if zone != "" && !s.zoneExists(w, zone) {
+		return
+	}
+	provider, ok := s.providers[providerType][name]
+	// A provider that belongs to an access zone is not visible from other access zones
+	if !ok || zone != "" && provider.ZoneName != "" && provider.ZoneName != zone {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find provider "+providerType+":"+name)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, map[string]interface{}{providerType: []interface{}{provider.object()}})
+	case "PUT":
+		var settings map[string]interface{}
+		if !decodeBody(w, r, &settings) {
+			return
+		}
+		for k, v := range settings {
+			provider.Settings[k] = v
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		if providerType == "local" || providerType == "file" && name == SystemZone {
+			writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", "The provider cannot be deleted")
+			return
+		}
+		delete(s.providers[providerType], name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleProviderSummary implements platform/<version>/auth/providers/summary. When a zone is given only the providers
+// of the access zone are returned
+func (s *Server) handleProviderSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		return
+	}
+	var zoneProviders map[string]bool
+	if zone := r.URL.Query().Get("zone"); zone != "" {
+		if !s.zoneExists(w, zone) {
+			return
+		}
+		zoneProviders = map[string]bool{}
+		for _, id := range s.zones[zone].AuthProviders {
+			zoneProviders[id] = true
+		}
+	}
+	instances := []interface{}{}
+	for _, providerType := range []string{"ads", "ldap", "nis", "file", "local"} {
+		for _, provider := range s.sortedProviders(providerType) {
+			if zoneProviders != nil && !zoneProviders[provider.id()] {
+				continue
+			}
+			instances = append(instances, map[string]interface{}{
+				"id":        provider.id(),
+				"name":      provider.Name,
+				"status":    provider.Status,
+				"type":      providerType,
+				"zone_name": provider.ZoneName,
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"provider_instances": instances})
+}
+
+// handleAdsSPN implements platform/<version>/auth/ads/<name>/spn and checks the registered service principal names
+// against the expected names
+func (s *Server) handleAdsSPN(w http.ResponseWriter, r *http.Request, name string) {
+	provider, ok := s.providers["ads"][name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find provider ads:"+name)
+		return
+	}
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		return
+	}
+	registered := map[string]bool{}
+	for _, spn := range provider.SPNs {
+		registered[spn] = true
+	}
+	missing := []string{}
+	for _, spn := range provider.expectedSPNs() {
+		if !registered[spn] {
+			missing = append(missing, spn)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"spns": map[string]interface{}{
+			"expected":   provider.expectedSPNs(),
+			"missing":    missing,
+			"registered": provider.SPNs,
+		},
+	})
+}
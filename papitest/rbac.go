@@ -0,0 +1,362 @@
+package papitest
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Privilege is a privilege that can be granted to a role. Permission is "r" for read, "x" for execute, "w" for write or
+// "-" for no access. Requests from clients of older API versions that set ReadOnly instead of Permission are accepted
+type Privilege struct {
+	ID         string `json:"id"`
+	Name       string `json:"name,omitempty"`
+	Permission string `json:"permission,omitempty"`
+	ReadOnly   bool   `json:"read_only,omitempty"`
+}
+
+// Role is an RBAC role in an access zone
+type Role struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Members     []ID        `json:"members"`
+	Privileges  []Privilege `json:"privileges"`
+}
+
+// knownPrivileges are the privileges returned by auth/privileges. Only these privileges can be granted to a role
+var knownPrivileges = []Privilege{
+	{ID: "ISI_PRIV_LOGIN_PAPI", Name: "Platform API"},
+	{ID: "ISI_PRIV_LOGIN_SSH", Name: "SSH"},
+	{ID: "ISI_PRIV_AUTH", Name: "Auth"},
+	{ID: "ISI_PRIV_ROLE", Name: "Role"},
+	{ID: "ISI_PRIV_NFS", Name: "NFS"},
+	{ID: "ISI_PRIV_SMB", Name: "SMB"},
+	{ID: "ISI_PRIV_S3", Name: "S3"},
+	{ID: "ISI_PRIV_QUOTA", Name: "Quota"},
+}
+
+// permissionRank orders permissions so the highest permission granted by multiple roles can be selected
+var permissionRank = map[string]int{"-": 0, "r": 1, "x": 2, "w": 3}
+
+// AddRole adds or replaces a role in an access zone. The zone is created if it does not exist. Members and privileges
+// are stored as given without checking that they exist
+func (s *Server) AddRole(zone string, role Role) {
+	if s.Zone(zone) == nil {
+		s.AddZone(Zone{Name: zone, Path: "/ifs/" + zone})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	role.ID = role.Name
+	s.roles[zone][role.Name] = &role
+}
+
+// Role returns a copy of a role in an access zone or nil if it does not exist
+func (s *Server) Role(zone string, name string) *Role {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	role, ok := s.roles[zone][name]
+	if !ok {
+		return nil
+	}
+	result := *role
+	result.Members = append([]ID(nil), role.Members...)
+	result.Privileges = append([]Privilege(nil), role.Privileges...)
+	return &result
+}
+
+// handlePrivileges implements platform/<version>/auth/privileges
+func (s *Server) handlePrivileges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"privileges": knownPrivileges, "total": len(knownPrivileges)})
+}
+
+// handleRoles implements platform/<version>/auth/roles and the resources below it. parts contains the path elements
+// after auth/roles
+func (s *Server) handleRoles(w http.ResponseWriter, r *http.Request, zone string, parts []string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	if len(parts) == 0 {
+		s.handleRoleList(w, r, zone)
+		return
+	}
+	role, ok := s.roles[zone][parts[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Role not found: "+parts[0])
+		return
+	}
+	switch {
+	case len(parts) == 1:
+		s.handleRole(w, r, zone, role)
+	case len(parts) == 2 && parts[1] == "members":
+		s.handleRoleMembers(w, r, zone, role)
+	case len(parts) == 3 && parts[1] == "members":
+		s.handleRoleMember(w, r, role, parts[2])
+	case len(parts) == 2 && parts[1] == "privileges":
+		s.handleRolePrivileges(w, r, role)
+	case len(parts) == 3 && parts[1] == "privileges":
+		s.handleRolePrivilege(w, r, role, parts[2])
+	default:
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Path not found: "+r.URL.Path)
+	}
+}
+
+// handleRoleList implements platform/<version>/auth/roles
+func (s *Server) handleRoleList(w http.ResponseWriter, r *http.Request, zone string) {
+	switch r.Method {
+	case "GET":
+		var items []interface{}
+		for _, name := range sortedKeys(s.roles[zone]) {
+			items = append(items, s.roles[zone][name])
+		}
+		s.writeList(w, r, "roles", items)
+	case "POST":
+		var role Role
+		if !decodeBody(w, r, &role) {
+			return
+		}
+		if role.Name == "" {
+			writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", "Field: name required")
+			return
+		}
+		if _, ok := s.roles[zone][role.Name]; ok {
+			writeError(w, http.StatusConflict, "AEC_CONFLICT", "Role already exists")
+			return
+		}
+		for i, privilege := range role.Privileges {
+			if !s.resolvePrivilege(w, &role.Privileges[i], privilege) {
+				return
+			}
+		}
+		for i, member := range role.Members {
+			if !s.resolveMember(w, zone, &role.Members[i], member) {
+				return
+			}
+		}
+		role.ID = role.Name
+		s.roles[zone][role.Name] = &role
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"id": role.ID})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleRole implements platform/<version>/auth/roles/<name>
+func (s *Server) handleRole(w http.ResponseWriter, r *http.Request, zone string, role *Role) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"roles": []*Role{role}})
+	case "PUT":
+		var req struct {
+			Description *string      `json:"description"`
+			Members     *[]ID        `json:"members"`
+			Privileges  *[]Privilege `json:"privileges"`
+		}
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		if req.Privileges != nil {
+			privileges := make([]Privilege, len(*req.Privileges))
+			for i, privilege := range *req.Privileges {
+				if !s.resolvePrivilege(w, &privileges[i], privilege) {
+					return
+				}
+			}
+			role.Privileges = privileges
+		}
+		if req.Members != nil {
+			members := make([]ID, len(*req.Members))
+			for i, member := range *req.Members {
+				if !s.resolveMember(w, zone, &members[i], member) {
+					return
+				}
+			}
+			role.Members = members
+		}
+		if req.Description != nil {
+			role.Description = *req.Description
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(s.roles[zone], role.Name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleRoleMembers implements platform/<version>/auth/roles/<name>/members
+func (s *Server) handleRoleMembers(w http.ResponseWriter, r *http.Request, zone string, role *Role) {
+	switch r.Method {
+	case "GET":
+		var items []interface{}
+		for _, member := range role.Members {
+			items = append(items, member)
+		}
+		s.writeList(w, r, "members", items)
+	case "POST":
+		var member ID
+		if !decodeBody(w, r, &member) || !s.resolveMember(w, zone, &member, member) {
+			return
+		}
+		for _, existing := range role.Members {
+			if existing.ID == member.ID {
+				writeError(w, http.StatusConflict, "AEC_CONFLICT", "Persona is already a member of the role")
+				return
+			}
+		}
+		role.Members = append(role.Members, member)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"id": member.ID})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleRoleMember implements platform/<version>/auth/roles/<name>/members/<member>. The member is given as
+// USER:<name>, GROUP:<name> or an ID like UID:<uid>
+func (s *Server) handleRoleMember(w http.ResponseWriter, r *http.Request, role *Role, member string) {
+	if r.Method != "DELETE" {
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		return
+	}
+	for i, existing := range role.Members {
+		if member == existing.ID || member == "USER:"+existing.Name && existing.Type == "user" || member == "GROUP:"+existing.Name && existing.Type == "group" {
+			role.Members = append(role.Members[:i], role.Members[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Persona is not a member of the role: "+member)
+}
+
+// handleRolePrivileges implements platform/<version>/auth/roles/<name>/privileges
+func (s *Server) handleRolePrivileges(w http.ResponseWriter, r *http.Request, role *Role) {
+	switch r.Method {
+	case "GET":
+		var items []interface{}
+		for _, privilege := range role.Privileges {
+			items = append(items, privilege)
+		}
+		s.writeList(w, r, "privileges", items)
+	case "POST":
+		var privilege Privilege
+		if !decodeBody(w, r, &privilege) || !s.resolvePrivilege(w, &privilege, privilege) {
+			return
+		}
+		for _, existing := range role.Privileges {
+			if existing.ID == privilege.ID {
+				writeError(w, http.StatusConflict, "AEC_CONFLICT", "Privilege is already granted to the role")
+				return
+			}
+		}
+		role.Privileges = append(role.Privileges, privilege)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"id": privilege.ID})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleRolePrivilege implements platform/<version>/auth/roles/<name>/privileges/<id>
+func (s *Server) handleRolePrivilege(w http.ResponseWriter, r *http.Request, role *Role, id string) {
+	if r.Method != "DELETE" {
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		return
+	}
+	for i, existing := range role.Privileges {
+		if existing.ID == id {
+			role.Privileges = append(role.Privileges[:i], role.Privileges[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Privilege is not granted to the role: "+id)
+}
+
+// resolvePrivilege completes a privilege from the list of known privileges and stores it in dst. An error response is
+// written and false is returned if the privilege or permission is invalid
+func (s *Server) resolvePrivilege(w http.ResponseWriter, dst *Privilege, privilege Privilege) bool {
+	if privilege.Permission == "" {
+		privilege.Permission = "w"
+		if privilege.ReadOnly {
+			privilege.Permission = "r"
+		}
+	}
+	if _, ok := permissionRank[privilege.Permission]; !ok {
+		writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", "Invalid permission: "+privilege.Permission)
+		return false
+	}
+	for _, known := range knownPrivileges {
+		if known.ID == privilege.ID {
+			*dst = Privilege{ID: known.ID, Name: known.Name, Permission: privilege.Permission, ReadOnly: privilege.Permission == "r"}
+			return true
+		}
+	}
+	writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Privilege not found: "+privilege.ID)
+	return false
+}
+
+// resolveMember completes a user or group persona given by name or ID and stores it in dst. An error response is
+// written and false is returned if the persona does not exist
+func (s *Server) resolveMember(w http.ResponseWriter, zone string, dst *ID, member ID) bool {
+	if member.Name == "" && member.ID != "" {
+		member.Name = member.ID[strings.Index(member.ID, ":")+1:]
+		if strings.HasPrefix(member.ID, "GROUP:") || strings.HasPrefix(member.ID, "GID:") {
+			member.Type = "group"
+		}
+	}
+	if member.Type == "" {
+		member.Type = "user"
+	}
+	if member.Type == "user" {
+		if user, ok := s.users[zone][member.Name]; ok {
+			*dst = ID{ID: user.UID.ID, Name: user.Name, Type: "user"}
+			return true
+		}
+	} else if group, ok := s.groups[zone][member.Name]; ok {
+		*dst = ID{ID: group.GID.ID, Name: group.Name, Type: "group"}
+		return true
+	}
+	writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find "+member.Type+" "+member.Name)
+	return false
+}
+
+// effectivePrivileges returns the privileges a user has through the roles of an access zone. A user has the
+// privileges of every role it is a member of directly or through one of its groups. When several roles grant the same
+// privilege the highest permission is used
+func (s *Server) effectivePrivileges(zone string, user string) []Privilege {
+	groups := map[string]bool{}
+	if u, ok := s.users[zone][user]; ok {
+		groups[u.PrimaryGroup.Name] = true
+		for _, group := range s.memberOf(zone, ID{Name: user, Type: "user"}) {
+			groups[group.Name] = true
+		}
+	}
+	var result []Privilege
+	index := map[string]int{}
+	for _, name := range sortedKeys(s.roles[zone]) {
+		role := s.roles[zone][name]
+		member := false
+		for _, m := range role.Members {
+			if (m.Type == "user" && m.Name == user) || (m.Type == "group" && groups[m.Name]) {
+				member = true
+				break
+			}
+		}
+		if !member {
+			continue
+		}
+		for _, privilege := range role.Privileges {
+			i, ok := index[privilege.ID]
+			if !ok {
+				index[privilege.ID] = len(result)
+				result = append(result, privilege)
+			} else if permissionRank[privilege.Permission] > permissionRank[result[i].Permission] {
+				result[i].Permission = privilege.Permission
+			}
+		}
+	}
+	return result
+}
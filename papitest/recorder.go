@@ -0,0 +1,256 @@
+package papitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/murkyl/go-papi-lite/internal/redact"
+)
+
+// RedactedValue replaces credentials, session tokens and secrets in recorded fixtures
+const RedactedValue string = redact.Value
+
+// Interaction is a single recorded request and response pair
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request in a fixture. URL only contains the path and query so fixtures do not depend on the
+// endpoint they were recorded against
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response in a fixture
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Recorder is an http.RoundTripper that passes requests to another RoundTripper and records every request and
+// response pair. Credentials, session cookies, CSRF tokens and secrets are scrubbed from the recorded values but the
+// cookie names are kept so the fixtures can be replayed with a Replayer. Use it with PapiSession.SetTransport
+//
+//	recorder := papitest.NewRecorder(nil)
+//	conn.SetTransport(recorder)
+//	// ... calls to the cluster
+//	err := recorder.Save("testdata/fixture.json")
+type Recorder struct {
+	inner        http.RoundTripper
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder returns a Recorder that sends requests with inner. http.DefaultTransport is used if inner is nil
+func NewRecorder(inner http.RoundTripper) *Recorder {
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+	return &Recorder{inner: inner}
+}
+
+// RoundTrip sends the request and records the scrubbed request and response
+func (rec *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	resp, err := rec.inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.interactions = append(rec.interactions, Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.RequestURI(),
+			Header: scrubHeader(req.Header),
+			Body:   scrubBody(reqBody),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     scrubHeader(resp.Header),
+			Body:       scrubBody(respBody),
+		},
+	})
+	return resp, nil
+}
+
+// Interactions returns a copy of all interactions recorded so far
+func (rec *Recorder) Interactions() []Interaction {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]Interaction(nil), rec.interactions...)
+}
+
+// Save writes all recorded interactions to a JSON fixture file
+func (rec *Recorder) Save(path string) error {
+	data, err := json.MarshalIndent(rec.Interactions(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Replayer is an http.RoundTripper that serves recorded interactions instead of sending requests. A request is
+// answered with the first unused interaction that has the same method, path and query. Interactions are used in the
+// order they were recorded so repeated requests, e.g. after a session expired, are replayed deterministically.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayer returns a Replayer for a list of interactions
+func NewReplayer(interactions []Interaction) *Replayer {
+	return &Replayer{
+		interactions: interactions,
+		used:         make([]bool, len(interactions)),
+	}
+}
+
+// LoadReplayer returns a Replayer for the interactions in a fixture file written by Recorder.Save
+func LoadReplayer(path string) (*Replayer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var interactions []Interaction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("[LoadReplayer] Invalid fixture file %s: %w", path, err)
+	}
+	return NewReplayer(interactions), nil
+}
+
+// RoundTrip returns the recorded response for the request. An error is returned if no unused interaction matches
+func (rep *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	for i, interaction := range rep.interactions {
+		if rep.used[i] || !requestMatches(interaction.Request, req) {
+			continue
+		}
+		rep.used[i] = true
+		header := interaction.Response.Header.Clone()
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode:    interaction.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(strings.NewReader(interaction.Response.Body)),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("[Replayer] No recorded interaction for %s %s", req.Method, req.URL.RequestURI())
+}
+
+// Remaining returns the number of recorded interactions that have not been replayed
+func (rep *Replayer) Remaining() int {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	count := 0
+	for _, used := range rep.used {
+		if !used {
+			count++
+		}
+	}
+	return count
+}
+
+// requestMatches compares the method, path and query of a recorded request with a live request
+func requestMatches(recorded RecordedRequest, req *http.Request) bool {
+	if recorded.Method != req.Method {
+		return false
+	}
+	recordedURL, err := url.Parse(recorded.URL)
+	if err != nil {
+		return false
+	}
+	return recordedURL.Path == req.URL.Path && reflect.DeepEqual(recordedURL.Query(), req.URL.Query())
+}
+
+// scrubHeader returns a copy of the headers with credentials and tokens replaced. Cookie names are kept
+func scrubHeader(header http.Header) http.Header {
+	result := http.Header{}
+	for k, values := range header {
+		switch http.CanonicalHeaderKey(k) {
+		case "Content-Length":
+			// Scrubbing can change the length of the body so the length is recalculated on replay
+			continue
+		case "Authorization", "X-Csrf-Token":
+			result[k] = []string{RedactedValue}
+		case "Cookie", "Set-Cookie":
+			for _, v := range values {
+				result[k] = append(result[k], scrubCookie(v))
+			}
+		default:
+			result[k] = append([]string(nil), values...)
+		}
+	}
+	return result
+}
+
+// scrubCookie replaces the values of all cookies in a Cookie or Set-Cookie header value while keeping the cookie names
+// and attributes like Path
+func scrubCookie(value string) string {
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.ToLower(kv[0]) {
+		case "path", "domain", "expires", "max-age", "samesite":
+			continue
+		}
+		parts[i] = part[:len(part)-len(strings.TrimLeft(part, " "))] + kv[0] + "=" + RedactedValue
+	}
+	return strings.Join(parts, ";")
+}
+
+// scrubBody replaces the values of JSON keys that contain credentials or secrets. Non JSON bodies are returned as is
+func scrubBody(body []byte) string {
+	var obj interface{}
+	if len(body) == 0 || json.Unmarshal(body, &obj) != nil {
+		return string(body)
+	}
+	data, err := json.Marshal(redact.JSON(obj))
+	if err != nil {
+		return string(body)
+	}
+	return string(data)
+}
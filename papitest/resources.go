@@ -0,0 +1,646 @@
+package papitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ID is a persona reference in the format used by PAPI
+type ID struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+}
+
+// Zone is an access zone
+type Zone struct {
+	AuthProviders    []string `json:"auth_providers"`
+	CacheEntryExpiry int      `json:"cache_entry_expiry"`
+	Groupnet         string   `json:"groupnet"`
+	ID               string   `json:"id"`
+	Name             string   `json:"name"`
+	Path             string   `json:"path"`
+	System           bool     `json:"system"`
+	UserMappingRules []string `json:"user_mapping_rules"`
+	ZoneID           int      `json:"zone_id"`
+}
+
+// User is a local user
+type User struct {
+	Name               string `json:"name"`
+	Email              string `json:"email,omitempty"`
+	Enabled            bool   `json:"enabled"`
+	Expiry             int    `json:"expiry,omitempty"`
+	Gecos              string `json:"gecos,omitempty"`
+	HomeDirectory      string `json:"home_directory,omitempty"`
+	Locked             bool   `json:"locked"`
+	MemberOf           []ID   `json:"member_of,omitempty"`
+	OnDiskUserIdentity ID     `json:"on_disk_user_identity"`
+	PasswordExpires    bool   `json:"password_expires"`
+	PrimaryGroup       ID     `json:"primary_group"`
+	Provider           string `json:"provider"`
+	Shell              string `json:"shell,omitempty"`
+	UID                ID     `json:"uid"`
+	SID                ID     `json:"sid"`
+	// Password is only used to create the user. It is never returned by the server
+	Password string `json:"-"`
+}
+
+// Group is a local group
+type Group struct {
+	Name     string `json:"name"`
+	GID      ID     `json:"gid"`
+	SID      ID     `json:"sid"`
+	MemberOf []ID   `json:"member_of,omitempty"`
+	// Members are the users and groups that are members of the group. They are returned by the members endpoint
+	Members []ID `json:"-"`
+}
+
+// S3Key is the S3 access key of a user
+type S3Key struct {
+	AccessID           string `json:"access_id"`
+	OldKeyExpiry       int    `json:"old_key_expiry"`
+	OldKeyTimestamp    int    `json:"old_key_timestamp"`
+	SecretKey          string `json:"secret_key"`
+	SecretKeyTimestamp int    `json:"secret_key_timestamp"`
+}
+
+// AddZone adds or replaces an access zone. A zone ID is assigned if none is set
+func (s *Server) AddZone(zone Zone) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if zone.ZoneID == 0 {
+		zone.ZoneID = len(s.zones) + 1
+	}
+	if zone.ID == "" {
+		zone.ID = zone.Name
+	}
+	if zone.Groupnet == "" {
+		zone.Groupnet = "groupnet0"
+	}
+	if zone.AuthProviders == nil {
+		zone.AuthProviders = []string{"lsa-local-provider:" + zone.Name, "lsa-file-provider:System"}
+	}
+	s.zones[zone.Name] = &zone
+	if s.users[zone.Name] == nil {
+		s.users[zone.Name] = map[string]*User{}
+		s.groups[zone.Name] = map[string]*Group{}
+		s.s3Keys[zone.Name] = map[string]*S3Key{}
+		s.roles[zone.Name] = map[string]*Role{}
+		s.addProvider(&Provider{Type: "local", Name: zone.Name, ZoneName: zone.Name})
+	}
+}
+
+// AddUser adds or replaces a user in an access zone. The zone is created if it does not exist. UID, SID and provider
+// values are assigned if none are set
+func (s *Server) AddUser(zone string, user User) {
+	if s.Zone(zone) == nil {
+		s.AddZone(Zone{Name: zone, Path: "/ifs/" + zone})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addUser(zone, &user)
+}
+
+// addUser completes the values of a user and stores it. The caller must hold the lock
+func (s *Server) addUser(zone string, user *User) {
+	id := s.newID()
+	if user.UID.ID == "" {
+		user.UID = ID{ID: fmt.Sprintf("UID:%d", id), Type: "user", Name: user.Name}
+	}
+	if user.SID.ID == "" {
+		user.SID = ID{ID: fmt.Sprintf("SID:S-1-5-21-1000-2000-3000-%d", id), Type: "user", Name: user.Name}
+	}
+	if user.PrimaryGroup.ID == "" {
+		user.PrimaryGroup = ID{ID: "GID:1800", Name: "Isilon Users", Type: "group"}
+	}
+	if user.OnDiskUserIdentity.ID == "" {
+		user.OnDiskUserIdentity = user.UID
+	}
+	if user.Provider == "" {
+		user.Provider = "lsa-local-provider:" + zone
+	}
+	s.users[zone][user.Name] = user
+}
+
+// AddGroup adds or replaces a group in an access zone. The zone is created if it does not exist. GID and SID values are
+// assigned if none are set
+func (s *Server) AddGroup(zone string, group Group) {
+	if s.Zone(zone) == nil {
+		s.AddZone(Zone{Name: zone, Path: "/ifs/" + zone})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addGroup(zone, &group)
+}
+
+// addGroup completes the values of a group and stores it. The caller must hold the lock
+func (s *Server) addGroup(zone string, group *Group) {
+	id := s.newID()
+	if group.GID.ID == "" {
+		group.GID = ID{ID: fmt.Sprintf("GID:%d", id), Type: "group", Name: group.Name}
+	}
+	if group.SID.ID == "" {
+		group.SID = ID{ID: fmt.Sprintf("SID:S-1-5-21-1000-2000-3000-%d", id), Type: "group", Name: group.Name}
+	}
+	s.groups[zone][group.Name] = group
+}
+
+// Zone returns a copy of an access zone or nil if it does not exist
+func (s *Server) Zone(name string) *Zone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	zone, ok := s.zones[name]
+	if !ok {
+		return nil
+	}
+	result := *zone
+	return &result
+}
+
+// User returns a copy of a user in an access zone or nil if it does not exist
+func (s *Server) User(zone string, name string) *User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[zone][name]
+	if !ok {
+		return nil
+	}
+	result := *user
+	return &result
+}
+
+// Group returns a copy of a group in an access zone or nil if it does not exist
+func (s *Server) Group(zone string, name string) *Group {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[zone][name]
+	if !ok {
+		return nil
+	}
+	result := *group
+	result.Members = append([]ID(nil), group.Members...)
+	return &result
+}
+
+// zoneExists writes an error response and returns false if the access zone does not exist
+func (s *Server) zoneExists(w http.ResponseWriter, zone string) bool {
+	if _, ok := s.zones[zone]; !ok {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Access zone not found: "+zone)
+		return false
+	}
+	return true
+}
+
+// handleClusterConfig implements platform/<version>/cluster/config
+func (s *Server) handleClusterConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name": "papitest",
+		"onefs_version": map[string]interface{}{
+			"build":    "B_" + strings.ReplaceAll(s.release, ".", "_"),
+			"release":  s.release,
+			"revision": "0",
+			"type":     "Isilon OneFS",
+			"version":  "Isilon OneFS v" + s.release,
+		},
+	})
+}
+
+// handleZones implements platform/<version>/zones
+func (s *Server) handleZones(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		return
+	}
+	var items []interface{}
+	for _, name := range sortedKeys(s.zones) {
+		items = append(items, s.zones[name])
+	}
+	s.writeList(w, r, "zones", items)
+}
+
+// handleUsers implements platform/<version>/auth/users
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request, zone string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	switch r.Method {
+	case "GET":
+		var items []interface{}
+		for _, name := range sortedKeys(s.users[zone]) {
+			items = append(items, s.users[zone][name])
+		}
+		s.writeList(w, r, "users", items)
+	case "POST":
+		var req struct {
+			User
+			Password     string `json:"password"`
+			PrimaryGroup *ID    `json:"primary_group"`
+			UID          *int   `json:"uid"`
+		}
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		if req.Name == "" {
+			writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", "Field: name required")
+			return
+		}
+		if _, ok := s.users[zone][req.Name]; ok {
+			writeError(w, http.StatusConflict, "AEC_CONFLICT", "User already exists")
+			return
+		}
+		user := req.User
+		user.Password = req.Password
+		if req.PrimaryGroup != nil {
+			user.PrimaryGroup = s.resolveGroupID(zone, *req.PrimaryGroup)
+		}
+		if req.UID != nil {
+			user.UID = ID{ID: fmt.Sprintf("UID:%d", *req.UID), Name: user.Name, Type: "user"}
+		}
+		s.addUser(zone, &user)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"id": user.SID.ID})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleUser implements platform/<version>/auth/users/<name>. The user can also be given as USER:<name>, UID:<uid> or
+// SID:<sid>
+func (s *Server) handleUser(w http.ResponseWriter, r *http.Request, zone string, name string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	user, ok := s.findUser(zone, name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find user for '"+personaRef("USER", name)+"': No such user")
+		return
+	}
+	name = user.Name
+	switch r.Method {
+	case "GET":
+		result := *user
+		if r.URL.Query().Get("query_member_of") != "" {
+			result.MemberOf = s.memberOf(zone, ID{ID: "USER:" + name, Name: name, Type: "user"})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"users": []User{result}})
+	case "PUT":
+		var req struct {
+			Email           *string `json:"email"`
+			Enabled         *bool   `json:"enabled"`
+			Expiry          *int    `json:"expiry"`
+			Gecos           *string `json:"gecos"`
+			HomeDirectory   *string `json:"home_directory"`
+			Password        *string `json:"password"`
+			PasswordExpires *bool   `json:"password_expires"`
+			PrimaryGroup    *ID     `json:"primary_group"`
+			Shell           *string `json:"shell"`
+			UID             *int    `json:"uid"`
+			Unlock          *bool   `json:"unlock"`
+		}
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		if req.Email != nil {
+			user.Email = *req.Email
+		}
+		if req.Enabled != nil {
+			user.Enabled = *req.Enabled
+		}
+		if req.Expiry != nil {
+			user.Expiry = *req.Expiry
+		}
+		if req.Gecos != nil {
+			user.Gecos = *req.Gecos
+		}
+		if req.HomeDirectory != nil {
+			user.HomeDirectory = *req.HomeDirectory
+		}
+		if req.Password != nil {
+			user.Password = *req.Password
+		}
+		if req.PasswordExpires != nil {
+			user.PasswordExpires = *req.PasswordExpires
+		}
+		if req.PrimaryGroup != nil {
+			user.PrimaryGroup = s.resolveGroupID(zone, *req.PrimaryGroup)
+		}
+		if req.Shell != nil {
+			user.Shell = *req.Shell
+		}
+		if req.UID != nil {
+			user.UID = ID{ID: fmt.Sprintf("UID:%d", *req.UID), Name: user.Name, Type: "user"}
+			user.OnDiskUserIdentity = user.UID
+		}
+		if req.Unlock != nil && *req.Unlock {
+			user.Locked = false
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(s.users[zone], name)
+		delete(s.s3Keys[zone], name)
+		for _, group := range s.groups[zone] {
+			group.Members = removeMember(group.Members, name, "user")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleChangePassword implements platform/<version>/auth/users/<name>/change-password
+func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request, zone string, name string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	user, ok := s.users[zone][name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find user for 'USER:"+name+"': No such user")
+		return
+	}
+	if r.Method != "PUT" {
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		return
+	}
+	var req struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.OldPassword != user.Password {
+		writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", "Password change failed: invalid old password")
+		return
+	}
+	user.Password = req.NewPassword
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// memberOf returns the groups a persona is a direct member of
+func (s *Server) memberOf(zone string, persona ID) []ID {
+	var result []ID
+	for _, name := range sortedKeys(s.groups[zone]) {
+		group := s.groups[zone][name]
+		for _, member := range group.Members {
+			if member.Name == persona.Name && member.Type == persona.Type {
+				result = append(result, ID{ID: group.GID.ID, Name: group.Name, Type: "group"})
+			}
+		}
+	}
+	return result
+}
+
+// resolveGroupID converts a group reference like GROUP:name or GID:1234 into the full ID of an existing group
+func (s *Server) resolveGroupID(zone string, ref ID) ID {
+	value := ref.ID
+	if strings.HasPrefix(value, "GROUP:") {
+		if group, ok := s.groups[zone][strings.TrimPrefix(value, "GROUP:")]; ok {
+			return ID{ID: group.GID.ID, Name: group.Name, Type: "group"}
+		}
+	}
+	for _, group := range s.groups[zone] {
+		if group.GID.ID == value || group.SID.ID == value {
+			return ID{ID: group.GID.ID, Name: group.Name, Type: "group"}
+		}
+	}
+	return ref
+}
+
+// handleGroups implements platform/<version>/auth/groups
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request, zone string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	switch r.Method {
+	case "GET":
+		var items []interface{}
+		for _, name := range sortedKeys(s.groups[zone]) {
+			items = append(items, s.groups[zone][name])
+		}
+		s.writeList(w, r, "groups", items)
+	case "POST":
+		var req struct {
+			Group
+			GID *int    `json:"gid"`
+			SID *string `json:"sid"`
+		}
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		if req.Name == "" {
+			writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", "Field: name required")
+			return
+		}
+		if _, ok := s.groups[zone][req.Name]; ok {
+			writeError(w, http.StatusConflict, "AEC_CONFLICT", "Group already exists")
+			return
+		}
+		group := req.Group
+		if req.GID != nil {
+			group.GID = ID{ID: fmt.Sprintf("GID:%d", *req.GID), Name: group.Name, Type: "group"}
+		}
+		if req.SID != nil {
+			group.SID = ID{ID: "SID:" + *req.SID, Name: group.Name, Type: "group"}
+		}
+		s.addGroup(zone, &group)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"id": group.SID.ID})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleGroup implements platform/<version>/auth/groups/<name>. The group can also be given as GROUP:<name>, GID:<gid>
+// or SID:<sid>
+func (s *Server) handleGroup(w http.ResponseWriter, r *http.Request, zone string, name string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	group, ok := s.findGroup(zone, name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find group for '"+personaRef("GROUP", name)+"': No such group")
+		return
+	}
+	name = group.Name
+	switch r.Method {
+	case "GET":
+		result := *group
+		if r.URL.Query().Get("query_member_of") != "" {
+			result.MemberOf = s.memberOf(zone, ID{ID: "GROUP:" + name, Name: name, Type: "group"})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"groups": []Group{result}})
+	case "PUT":
+		var req struct {
+			GID *int    `json:"gid"`
+			SID *string `json:"sid"`
+		}
+		if !decodeBody(w, r, &req) {
+			return
+		}
+		if req.GID != nil {
+			group.GID = ID{ID: fmt.Sprintf("GID:%d", *req.GID), Name: group.Name, Type: "group"}
+		}
+		if req.SID != nil {
+			group.SID = ID{ID: "SID:" + *req.SID, Name: group.Name, Type: "group"}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(s.groups[zone], name)
+		for _, other := range s.groups[zone] {
+			other.Members = removeMember(other.Members, name, "group")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleGroupMembers implements platform/<version>/auth/groups/<name>/members
+func (s *Server) handleGroupMembers(w http.ResponseWriter, r *http.Request, zone string, name string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	group, ok := s.groups[zone][name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find group for 'GROUP:"+name+"': No such group")
+		return
+	}
+	switch r.Method {
+	case "GET":
+		var items []interface{}
+		for _, member := range group.Members {
+			items = append(items, member)
+		}
+		s.writeList(w, r, "members", items)
+	case "POST":
+		var member ID
+		if !decodeBody(w, r, &member) {
+			return
+		}
+		if member.Name == "" && member.ID != "" {
+			member.Name = member.ID[strings.Index(member.ID, ":")+1:]
+		}
+		if member.Type == "" {
+			member.Type = "user"
+		}
+		if (member.Type == "user" && s.users[zone][member.Name] == nil) || (member.Type == "group" && s.groups[zone][member.Name] == nil) {
+			writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find "+member.Type+" "+member.Name)
+			return
+		}
+		for _, existing := range group.Members {
+			if existing.Name == member.Name && existing.Type == member.Type {
+				writeError(w, http.StatusConflict, "AEC_CONFLICT", "Persona is already a member of the group")
+				return
+			}
+		}
+		if member.Type == "user" {
+			member.ID = s.users[zone][member.Name].UID.ID
+		} else {
+			member.ID = s.groups[zone][member.Name].GID.ID
+		}
+		group.Members = append(group.Members, member)
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"id": member.ID})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// handleGroupMember implements platform/<version>/auth/groups/<name>/members/<member>. The member is given as
+// USER:<name>, GROUP:<name> or an ID like UID:<uid>
+func (s *Server) handleGroupMember(w http.ResponseWriter, r *http.Request, zone string, name string, member string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	group, ok := s.groups[zone][name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find group for 'GROUP:"+name+"': No such group")
+		return
+	}
+	if r.Method != "DELETE" {
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+		return
+	}
+	for i, existing := range group.Members {
+		if member == existing.ID || member == "USER:"+existing.Name && existing.Type == "user" || member == "GROUP:"+existing.Name && existing.Type == "group" {
+			group.Members = append(group.Members[:i], group.Members[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Persona is not a member of the group: "+member)
+}
+
+// removeMember removes a persona from a list of group members
+func removeMember(members []ID, name string, memberType string) []ID {
+	result := members[:0]
+	for _, member := range members {
+		if member.Name != name || member.Type != memberType {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+// handleS3Keys implements platform/<version>/protocols/s3/keys/<user>
+func (s *Server) handleS3Keys(w http.ResponseWriter, r *http.Request, zone string, name string) {
+	if !s.zoneExists(w, zone) {
+		return
+	}
+	if _, ok := s.users[zone][name]; !ok {
+		writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "Failed to find user for 'USER:"+name+"': No such user")
+		return
+	}
+	key := s.s3Keys[zone][name]
+	switch r.Method {
+	case "GET":
+		if key == nil {
+			writeError(w, http.StatusNotFound, "AEC_NOT_FOUND", "No S3 key for user "+name)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": key})
+	case "POST":
+		var req struct {
+			ExistingKeyExpiryTime int `json:"existing_key_expiry_time"`
+		}
+		if err := decodeOptionalBody(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "AEC_BAD_REQUEST", "Invalid JSON body: "+err.Error())
+			return
+		}
+		if key != nil && r.URL.Query().Get("force") == "" {
+			writeError(w, http.StatusConflict, "AEC_CONFLICT", "Secret key already exists")
+			return
+		}
+		now := int(s.clock().Unix())
+		newKey := &S3Key{
+			AccessID:           fmt.Sprintf("%d_%s_accid", s.zones[zone].ZoneID, name),
+			SecretKey:          randomToken(),
+			SecretKeyTimestamp: now,
+		}
+		if key != nil {
+			newKey.OldKeyTimestamp = key.SecretKeyTimestamp
+			newKey.OldKeyExpiry = now + req.ExistingKeyExpiryTime*60
+		}
+		s.s3Keys[zone][name] = newKey
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": newKey})
+	case "DELETE":
+		delete(s.s3Keys[zone], name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "AEC_BAD_REQUEST", "Method not allowed: "+r.Method)
+	}
+}
+
+// decodeOptionalBody decodes the JSON body of a request into obj. An empty body is not an error
+func decodeOptionalBody(r *http.Request, obj interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(obj)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}